@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const HeaderXAdminToken = "X-Admin-Token"
+
+// adminAuth checks admin token. It doesn't use IAM: admin token is a
+// separate operator credential, so normal user tokens never pass it.
+func (s *Server) adminAuth(c *fiber.Ctx) error {
+	token := c.Get(HeaderXAdminToken)
+
+	if s.config.AdminToken == "" || token == "" ||
+		subtle.ConstantTimeCompare([]byte(token),
+			[]byte(s.config.AdminToken)) != 1 {
+		return errorResponse(c, http.StatusUnauthorized,
+			"invalid_admin_token", "admin token is absent or invalid")
+	}
+
+	return c.Next()
+}
+
+func (s *Server) adminListDashboards(c *fiber.Ctx) error {
+	var where []goqu.Expression
+
+	if userID := c.Query("user_id"); userID != "" {
+		where = append(where, goqu.Ex{"user_id": userID})
+	}
+
+	search, err := dashboardsSearch(c.Query("q"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_search",
+			err.Error())
+	}
+	if search != nil {
+		where = append(where, search)
+	}
+
+	return s.paginateDashboards(c, goqu.And(where...), adminDashboardFields,
+		adminListDashboardFields)
+}
+
+// userIDRe matches IAM user ID.
+var userIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// CopyToReq is a dashboard copy request. Source dashboard name is used if
+// name is empty.
+type CopyToReq struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// adminIdempotencyOwner owns admin idempotency keys. It isn't a valid user
+// ID, so admin keys never clash with user ones.
+const adminIdempotencyOwner = "admin"
+
+// nameConflict is an error of dashboard name already used by the target
+// user.
+type nameConflict []ValidationError
+
+func (nc nameConflict) Error() string {
+	return validationErrors(nc).Error()
+}
+
+type copyAuditDetails struct {
+	Name              string `json:"name"`
+	SourceDashboardID int    `json:"source_dashboard_id"`
+	SourceUserID      string `json:"source_user_id"`
+	TargetUserID      string `json:"target_user_id"`
+}
+
+// adminCopyDashboard copies any user dashboard to the target user. The copy
+// is recorded to the target user audit log with both source and target.
+func (s *Server) adminCopyDashboard(c *fiber.Ctx) error {
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	var r CopyToReq
+
+	err = json.Unmarshal(c.Body(), &r)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal copy request",
+			err)
+	}
+
+	if !userIDRe.MatchString(r.UserID) {
+		return errorResponse(c, http.StatusBadRequest, "invalid_user_id",
+			"user_id must be 32 lowercase hex characters")
+	}
+
+	ctx := c.UserContext()
+
+	var d Dashboard
+
+	found, err := s.db.Select("id", "user_id", "name",
+		goqu.COALESCE(goqu.C("description"), "").As("description"),
+		"graphs", goqu.COALESCE(goqu.C("layout"), goqu.L("'null'::jsonb")).
+			As("layout")).From("dashboard").
+		Where(goqu.Ex{"id": dashboardID}).
+		Executor().ScanStructContext(ctx, &d)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	if r.Name == "" {
+		r.Name = d.Name
+	}
+
+	r.Name, err = validateName(r.Name)
+	if err != nil {
+		return nameErrorResponse(c, err)
+	}
+
+	var createdID int
+
+	// Retried copy finds the dashboard it created by the natural key of the
+	// target user, name and source, so it's responded instead of name
+	// conflict. Copies of the same source with other names are independent.
+	existingFn := func(tx *goqu.TxDatabase) (interface{}, error) {
+		id, found, err := copiedDashboardID(ctx, tx, r.UserID, r.Name, d.ID)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return AddDashboardsRes{ID: id}, nil
+		}
+
+		errs, err := checkNameUnique(ctx, tx, r.UserID, r.Name, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(errs) > 0 {
+			return nil, nameConflict(errs)
+		}
+
+		return nil, nil
+	}
+
+	copyFn := func(tx *goqu.TxDatabase) (interface{}, error) {
+		res, err := existingFn(tx)
+		if res != nil || err != nil {
+			return res, err
+		}
+
+		// Concurrent copy may insert the same dashboard after the check
+		// above. Conflicting insert waits for it and inserts nothing, then
+		// the check sees the committed dashboard.
+		var id int
+
+		inserted, err := tx.Insert("dashboard").
+			Cols("user_id", "name", "description", "graphs", "layout",
+				"copied_from").
+			Vals(goqu.Vals{r.UserID, r.Name, textOrNull(d.Description),
+				goqu.L("?::jsonb", string(d.Graphs)), jsonbOrNull(d.Layout),
+				d.ID}).
+			OnConflict(goqu.DoNothing()).
+			Returning("id").Executor().ScanValContext(ctx, &id)
+		if err != nil {
+			return nil, err
+		}
+		if !inserted {
+			res, err = existingFn(tx)
+			if res == nil && err == nil {
+				err = errors.New("dashboard copy conflicts, but no" +
+					" conflicting dashboard found")
+			}
+			return res, err
+		}
+
+		audit(ctx, tx, r.UserID, auditCopy, id, copyAuditDetails{
+			Name:              r.Name,
+			SourceDashboardID: d.ID,
+			SourceUserID:      d.UserID,
+			TargetUserID:      r.UserID,
+		})
+
+		createdID = id
+
+		return AddDashboardsRes{ID: id}, nil
+	}
+
+	var res json.RawMessage
+
+	if key := c.Get(HeaderIdempotencyKey); key != "" {
+		if len(key) > maxIdempotencyKeyLen {
+			return errorResponse(c, http.StatusBadRequest,
+				"invalid_idempotency_key", "idempotency key is too long")
+		}
+		res, err = s.doIdempotent(ctx, adminIdempotencyOwner, key,
+			requestHash(c.Body()), copyFn)
+	} else {
+		err = withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+			v, err := copyFn(tx)
+			if err != nil {
+				return err
+			}
+			res, err = json.Marshal(v)
+			return err
+		})
+	}
+	if err != nil {
+		if errors.Is(err, errIdempotencyKeyReused) {
+			return errorResponse(c, http.StatusUnprocessableEntity,
+				"idempotency_key_reused", err.Error())
+		}
+		var nc nameConflict
+		if errors.As(err, &nc) {
+			return errorDetailsResponse(c, http.StatusConflict,
+				"name_conflict",
+				"target user already has dashboard with such name", nc)
+		}
+		return internalError(c, "failed to insert dashboard to db", err)
+	}
+
+	if createdID != 0 {
+		logger.Infof("[admin] copied dashboard %d of user %s to user %s as"+
+			" dashboard %d", d.ID, d.UserID, r.UserID, createdID)
+	}
+
+	return sendIdempotentResponse(c, res)
+}
+
+// copiedDashboardID returns ID of the target user dashboard with the name
+// copied from the source dashboard.
+func copiedDashboardID(ctx context.Context, tx *goqu.TxDatabase,
+	userID, name string, sourceID int) (int, bool, error) {
+
+	var id int
+
+	found, err := tx.Select("id").From("dashboard").
+		Where(goqu.Ex{"user_id": userID, "name": name,
+			"copied_from": sourceID}).
+		Executor().ScanValContext(ctx, &id)
+
+	return id, found, err
+}
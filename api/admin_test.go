@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+// fakeQuery is a statement run on fakeQueryDB.
+type fakeQuery struct {
+	query string
+	inTx  bool
+}
+
+// fakeQueryDB is a database/sql driver which answers queries with rows of
+// the handler. It logs every statement with whether it's run within a
+// transaction.
+type fakeQueryDB struct {
+	mx sync.Mutex
+
+	handle  func(query string) ([]string, [][]driver.Value)
+	queries []fakeQuery
+}
+
+func (db *fakeQueryDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeQueryConn{db: db}, nil
+}
+
+func (db *fakeQueryDB) Driver() driver.Driver {
+	return nil
+}
+
+// log logs the query.
+func (db *fakeQueryDB) log(query string, inTx bool) {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	db.queries = append(db.queries, fakeQuery{query: query, inTx: inTx})
+}
+
+// find returns logged queries containing the s.
+func (db *fakeQueryDB) find(s string) []fakeQuery {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	var qs []fakeQuery
+	for _, q := range db.queries {
+		if strings.Contains(q.query, s) {
+			qs = append(qs, q)
+		}
+	}
+	return qs
+}
+
+type fakeQueryConn struct {
+	db   *fakeQueryDB
+	inTx bool
+}
+
+func (c *fakeQueryConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare isn't supported")
+}
+
+func (c *fakeQueryConn) Close() error {
+	return nil
+}
+
+func (c *fakeQueryConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeQueryConn) Commit() error {
+	c.inTx = false
+	return nil
+}
+
+func (c *fakeQueryConn) Rollback() error {
+	c.inTx = false
+	return nil
+}
+
+func (c *fakeQueryConn) ExecContext(_ context.Context, query string,
+	_ []driver.NamedValue) (driver.Result, error) {
+
+	c.db.log(query, c.inTx)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeQueryConn) QueryContext(_ context.Context, query string,
+	_ []driver.NamedValue) (driver.Rows, error) {
+
+	c.db.log(query, c.inTx)
+	cols, rows := c.db.handle(query)
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// copyDB answers the source dashboard 1 of the other user. The copied
+// dashboard lookups are answered with the copied rows in order, the insert
+// is answered with the inserted rows.
+type copyDB struct {
+	copied   [][][]driver.Value
+	inserted [][]driver.Value
+}
+
+func (d *copyDB) handle(query string) ([]string, [][]driver.Value) {
+	switch {
+	case strings.Contains(query, "COALESCE"):
+		return []string{"id", "user_id", "name", "description", "graphs",
+				"layout"},
+			[][]driver.Value{{int64(1), "other", "source", "", []byte("[]"),
+				[]byte("null")}}
+	case strings.Contains(query, `"copied_from" =`):
+		var rows [][]driver.Value
+		if len(d.copied) > 0 {
+			rows, d.copied = d.copied[0], d.copied[1:]
+		}
+		return []string{"id"}, rows
+	case strings.HasPrefix(query, "INSERT"):
+		return []string{"id"}, d.inserted
+	}
+	return []string{"id"}, nil
+}
+
+const (
+	testAdminToken   = "admin"
+	copyTargetUserID = "0123456789abcdef0123456789abcdef"
+)
+
+func newCopyTestApp(t *testing.T, d *copyDB) (*fakeQueryDB, *fiber.App) {
+	t.Helper()
+
+	return newFakeDBTestApp(t, d.handle)
+}
+
+// newFakeDBTestApp creates app serving Server routes with fakeQueryDB
+// answering queries with the handle.
+func newFakeDBTestApp(t *testing.T,
+	handle func(query string) ([]string, [][]driver.Value)) (*fakeQueryDB,
+	*fiber.App) {
+
+	t.Helper()
+
+	fdb := &fakeQueryDB{handle: handle}
+	db := goqu.New("postgres", sql.OpenDB(fdb))
+
+	s, err := NewServer(db, nil, core.Signer{}, testVerifier{},
+		http.DefaultClient, Config{
+			AdminToken:       testAdminToken,
+			CESPathAllowlist: []string{".*"},
+			DBTimeout:        time.Minute,
+		})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	app := newFiberApp()
+	s.RegisterRoutes(app)
+
+	return fdb, app
+}
+
+func copyDashboard(t *testing.T, app *fiber.App, name string) (int,
+	map[string]interface{}) {
+
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, "/admin/dashboards/1/copy-to",
+		strings.NewReader(`{"user_id": "`+copyTargetUserID+`", "name": "`+
+			name+`"}`))
+	req.Header.Set(HeaderXAdminToken, testAdminToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+	defer res.Body.Close()
+
+	var body map[string]interface{}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	return res.StatusCode, body
+}
+
+// checkCopyInTx checks that every copy statement is run within the tx.
+func checkCopyInTx(t *testing.T, fdb *fakeQueryDB) {
+	t.Helper()
+
+	for _, s := range []string{`"copied_from" =`, `"name" =`, "INSERT"} {
+		for _, q := range fdb.find(s) {
+			if !q.inTx {
+				t.Errorf("query run outside of tx: %s", q.query)
+			}
+		}
+	}
+}
+
+func TestAdminCopyDashboard(t *testing.T) {
+	fdb, app := newCopyTestApp(t, &copyDB{
+		inserted: [][]driver.Value{{int64(9)}},
+	})
+
+	status, body := copyDashboard(t, app, "copy")
+	if status != http.StatusOK || body["id"] != float64(9) {
+		t.Fatalf("got %d %v, want 200 with ID 9", status, body)
+	}
+
+	checkCopyInTx(t, fdb)
+
+	inserts := fdb.find(`INSERT INTO "dashboard"`)
+	if len(inserts) != 1 || !strings.Contains(inserts[0].query,
+		`"copied_from"`) {
+		t.Errorf("got inserts %v, want one with copied_from", inserts)
+	}
+}
+
+func TestAdminCopyDashboardRetried(t *testing.T) {
+	fdb, app := newCopyTestApp(t, &copyDB{
+		copied: [][][]driver.Value{{{int64(7)}}},
+	})
+
+	status, body := copyDashboard(t, app, "copy")
+	if status != http.StatusOK || body["id"] != float64(7) {
+		t.Fatalf("got %d %v, want 200 with ID 7", status, body)
+	}
+
+	checkCopyInTx(t, fdb)
+
+	if inserts := fdb.find("INSERT"); len(inserts) != 0 {
+		t.Errorf("got inserts %v, want none", inserts)
+	}
+}
+
+func TestAdminCopyDashboardOtherName(t *testing.T) {
+	// Copy of the same source with other name isn't a retry, so it's
+	// looked up by the name and inserted.
+	fdb, app := newCopyTestApp(t, &copyDB{
+		inserted: [][]driver.Value{{int64(10)}},
+	})
+
+	status, body := copyDashboard(t, app, "second copy")
+	if status != http.StatusOK || body["id"] != float64(10) {
+		t.Fatalf("got %d %v, want 200 with ID 10", status, body)
+	}
+
+	lookups := fdb.find(`"copied_from" =`)
+	if len(lookups) != 1 || !strings.Contains(lookups[0].query,
+		`"name" = 'second copy'`) {
+		t.Errorf("got lookups %v, want one by the name", lookups)
+	}
+}
+
+func TestAdminCopyDashboardConcurrent(t *testing.T) {
+	// Concurrent copy is committed between the check and the insert, so
+	// insert inserts nothing and the second check finds the copy.
+	fdb, app := newCopyTestApp(t, &copyDB{
+		copied: [][][]driver.Value{nil, {{int64(8)}}},
+	})
+
+	status, body := copyDashboard(t, app, "copy")
+	if status != http.StatusOK || body["id"] != float64(8) {
+		t.Fatalf("got %d %v, want 200 with ID 8", status, body)
+	}
+
+	checkCopyInTx(t, fdb)
+
+	if inserts := fdb.find("audit_log"); len(inserts) != 0 {
+		t.Errorf("got audit inserts %v, want none", inserts)
+	}
+}
+
+func TestAdminListDashboardsSearch(t *testing.T) {
+	fdb, app := newFakeDBTestApp(t,
+		func(query string) ([]string, [][]driver.Value) {
+			return []string{"id"}, nil
+		})
+
+	for _, tc := range []struct {
+		target string
+		want   []string
+	}{
+		{"/admin/dashboards", nil},
+		{"/admin/dashboards?q=cpu", []string{`"name" ILIKE '%cpu%'`,
+			`"description" ILIKE '%cpu%'`}},
+		{"/admin/dashboards?user_id=" + copyTargetUserID + "&q=cpu",
+			[]string{`"user_id" = '` + copyTargetUserID + `'`,
+				`"name" ILIKE '%cpu%'`}},
+	} {
+		fdb.mx.Lock()
+		fdb.queries = nil
+		fdb.mx.Unlock()
+
+		req, _ := http.NewRequest(http.MethodGet, tc.target, nil)
+		req.Header.Set(HeaderXAdminToken, testAdminToken)
+
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("failed to do request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s got status %d, want %d", tc.target, res.StatusCode,
+				http.StatusOK)
+		}
+
+		qs := fdb.find("SELECT")
+		if len(qs) != 1 {
+			t.Fatalf("%s got queries %v, want one", tc.target, qs)
+		}
+		if tc.want == nil && strings.Contains(qs[0].query, "WHERE") {
+			t.Errorf("%s got query %s, want no filter", tc.target,
+				qs[0].query)
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(qs[0].query, w) {
+				t.Errorf("%s got query %s, want %s", tc.target, qs[0].query, w)
+			}
+		}
+	}
+}
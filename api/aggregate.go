@@ -0,0 +1,216 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const (
+	maxAggregateBuckets = 1000
+	maxDimensions       = 4
+)
+
+var aggregations = map[string]func(vs []float64) float64{
+	"avg": func(vs []float64) float64 {
+		sum := 0.
+		for _, v := range vs {
+			sum += v
+		}
+		return sum / float64(len(vs))
+	},
+	"max": func(vs []float64) float64 {
+		max := math.Inf(-1)
+		for _, v := range vs {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"min": func(vs []float64) float64 {
+		min := math.Inf(1)
+		for _, v := range vs {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"sum": func(vs []float64) float64 {
+		sum := 0.
+		for _, v := range vs {
+			sum += v
+		}
+		return sum
+	},
+}
+
+type AggregateBucket struct {
+	From  int64    `json:"from"`
+	To    int64    `json:"to"`
+	Count int      `json:"count"`
+	Value *float64 `json:"value"`
+}
+
+type AggregateRes struct {
+	Namespace  string            `json:"namespace"`
+	MetricName string            `json:"metric_name"`
+	Agg        string            `json:"agg"`
+	Buckets    []AggregateBucket `json:"buckets"`
+}
+
+// parseAggregateQuery parses and validates aggregate request query. Time
+// range must not exceed maxRange unless it's zero.
+func parseAggregateQuery(c *fiber.Ctx, maxRange time.Duration) (
+	core.MetricDataQuery, string, int, []ValidationError) {
+
+	var errs []ValidationError
+
+	mq := core.MetricDataQuery{
+		Namespace:  c.Query("namespace"),
+		MetricName: c.Query("metric_name"),
+		Period:     c.Query("period", "1"),
+		Filter:     c.Query("filter", "average"),
+	}
+
+	if mq.Namespace == "" {
+		errs = append(errs, ValidationError{Field: "namespace",
+			Message: "must not be empty"})
+	}
+	if mq.MetricName == "" {
+		errs = append(errs, ValidationError{Field: "metric_name",
+			Message: "must not be empty"})
+	}
+
+	for i := 0; i < maxDimensions; i++ {
+		field := "dim." + strconv.Itoa(i)
+		d := c.Query(field)
+		if d == "" {
+			break
+		}
+		kv := strings.SplitN(d, ",", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			errs = append(errs, ValidationError{Field: field,
+				Message: "must be name,value pair"})
+			continue
+		}
+		mq.Dimensions = append(mq.Dimensions,
+			Dimension{Name: kv[0], Value: kv[1]})
+	}
+	if len(mq.Dimensions) == 0 {
+		errs = append(errs, ValidationError{Field: "dim.0",
+			Message: "must not be empty"})
+	}
+
+	var trErrs []ValidationError
+
+	mq.From, mq.To, trErrs = normalizeTimeRange(c.Query("from"),
+		c.Query("to"), maxRange)
+	errs = append(errs, trErrs...)
+	if !cesPeriods[mq.Period] {
+		errs = append(errs, ValidationError{Field: "period",
+			Message: fmt.Sprintf("unsupported period %q", mq.Period)})
+	}
+	if !cesFilters[mq.Filter] {
+		errs = append(errs, ValidationError{Field: "filter",
+			Message: fmt.Sprintf("unsupported filter %q", mq.Filter)})
+	}
+
+	agg := c.Query("agg", "avg")
+	if aggregations[agg] == nil {
+		errs = append(errs, ValidationError{Field: "agg",
+			Message: fmt.Sprintf("unsupported aggregation %q", agg)})
+	}
+
+	buckets, err := strconv.Atoi(c.Query("buckets", "1"))
+	if err != nil || buckets < 1 || buckets > maxAggregateBuckets {
+		errs = append(errs, ValidationError{Field: "buckets",
+			Message: fmt.Sprintf("must be integer from 1 to %d",
+				maxAggregateBuckets)})
+	}
+
+	return mq, agg, buckets, errs
+}
+
+// aggregateDatapoints splits [from, to) into equal buckets and aggregates
+// filter values of datapoints in each bucket. Empty buckets have null value.
+func aggregateDatapoints(dps []core.Datapoint, filter, agg string,
+	from, to int64, buckets int) []AggregateBucket {
+
+	width := (to - from) / int64(buckets)
+	if width < 1 {
+		width = 1
+	}
+
+	bs := make([]AggregateBucket, buckets)
+	vs := make([][]float64, buckets)
+
+	for i := range bs {
+		bs[i].From = from + int64(i)*width
+		bs[i].To = bs[i].From + width
+	}
+	bs[buckets-1].To = to
+
+	for _, dp := range dps {
+		v := dp.Value(filter)
+		if v == nil || dp.Timestamp < from || dp.Timestamp >= to {
+			continue
+		}
+		i := int((dp.Timestamp - from) / width)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		vs[i] = append(vs[i], *v)
+	}
+
+	for i := range bs {
+		bs[i].Count = len(vs[i])
+		if len(vs[i]) > 0 {
+			v := aggregations[agg](vs[i])
+			bs[i].Value = &v
+		}
+	}
+
+	return bs
+}
+
+func (s *Server) cesAggregate(c *fiber.Ctx) error {
+	mq, agg, buckets, errs := parseAggregateQuery(c, s.config.MaxTimeRange)
+	if len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_aggregate_query", "aggregate query is invalid", errs)
+	}
+
+	md, err := s.ces.GetMetricData(c.UserContext(), mq)
+	if err != nil {
+		var cesErr *core.CESError
+		if errors.As(err, &cesErr) {
+			if cesErr.StatusCode == http.StatusUnauthorized ||
+				cesErr.StatusCode == http.StatusForbidden {
+				return errorDetailsResponse(c, http.StatusForbidden,
+					"ces_forbidden", "CES access denied", CESErrorDetails{
+						UpstreamStatus: cesErr.StatusCode,
+					})
+			}
+			logger.Warnf("[ces aggregate] failed to get metric data: %v", err)
+			return errorDetailsResponse(c, http.StatusBadGateway,
+				codeCESUnavailable, "failed to get CES metric data",
+				CESErrorDetails{UpstreamStatus: cesErr.StatusCode})
+		}
+		return cesRequestError(c, err)
+	}
+
+	return c.JSON(AggregateRes{
+		Namespace:  mq.Namespace,
+		MetricName: mq.MetricName,
+		Agg:        agg,
+		Buckets: aggregateDatapoints(md.Datapoints, mq.Filter, agg,
+			mq.From, mq.To, buckets),
+	})
+}
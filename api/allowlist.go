@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var errCESPathNotAllowed = errors.New("CES path is not allowed")
+
+// compileCESPaths compiles CES paths patterns. Patterns are anchored: they
+// must match the whole path.
+func compileCESPaths(patterns []string) ([]*regexp.Regexp, error) {
+	rs := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		r, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile CES path pattern %q: %w",
+				p, err)
+		}
+		rs[i] = r
+	}
+	return rs, nil
+}
+
+// checkCESPath checks that unescaped CES path is normalized and allowed.
+// Dot and empty segments, backslashes, control characters and escapes left
+// after unescaping are rejected, so they can't be used to bypass the
+// allowlist.
+func (s *Server) checkCESPath(path string) error {
+	if strings.ContainsAny(path, "\\%") {
+		return errCESPathNotAllowed
+	}
+
+	for _, r := range path {
+		if unicode.IsControl(r) {
+			return errCESPathNotAllowed
+		}
+	}
+
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return errCESPathNotAllowed
+		}
+	}
+
+	for _, r := range s.cesPathAllowlist {
+		if r.MatchString(path) {
+			return nil
+		}
+	}
+
+	return errCESPathNotAllowed
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+	auditCopy   = "copy"
+)
+
+var auditActions = map[string]bool{
+	auditCreate: true,
+	auditUpdate: true,
+	auditDelete: true,
+	auditCopy:   true,
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+type AuditEntry struct {
+	ID          int             `db:"id" json:"id"`
+	UserID      string          `db:"user_id" json:"user_id"`
+	Action      string          `db:"action" json:"action"`
+	DashboardID int             `db:"dashboard_id" json:"dashboard_id"`
+	At          time.Time       `db:"at" json:"at"`
+	Details     json.RawMessage `db:"details" json:"details"`
+	IP          string          `db:"ip" json:"ip,omitempty"`
+}
+
+type AuditEntriesRes struct {
+	History []AuditEntry `json:"history"`
+}
+
+// audit records dashboard mutation within the tx. Recording is best-effort:
+// it is guarded by a savepoint, so its failure is logged and doesn't abort
+// the mutation itself.
+func audit(ctx context.Context, tx *goqu.TxDatabase, userID, action string,
+	dashboardID int, details interface{}) {
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		logger.Errorf("[audit] failed to JSON marshal details: user_id=%s"+
+			" action=%s dashboard_id=%d: %v", userID, action, dashboardID, err)
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "savepoint audit")
+	if err != nil {
+		logger.Errorf("[audit] failed to create savepoint: user_id=%s"+
+			" action=%s dashboard_id=%d: %v", userID, action, dashboardID, err)
+		return
+	}
+
+	_, err = tx.Insert("audit_log").
+		Cols("user_id", "action", "dashboard_id", "details", "ip").
+		Vals(goqu.Vals{userID, action, dashboardID,
+			goqu.L("?::jsonb", string(detailsJSON)),
+			textOrNull(clientIP(ctx))}).
+		Executor().ExecContext(ctx)
+	if err != nil {
+		logger.Errorf("[audit] failed to insert audit log entry: user_id=%s"+
+			" action=%s dashboard_id=%d: %v", userID, action, dashboardID, err)
+
+		_, err = tx.ExecContext(ctx, "rollback to savepoint audit")
+		if err != nil {
+			logger.Errorf("[audit] failed to rollback to savepoint: %v", err)
+		}
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "release savepoint audit")
+	if err != nil {
+		logger.Errorf("[audit] failed to release savepoint: %v", err)
+	}
+}
+
+// parseHistoryTime parses since or until query param to time.
+func parseHistoryTime(c *fiber.Ctx, key string) (time.Time,
+	[]ValidationError) {
+
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	ms, err := parseTimeParam(v)
+	if err != nil {
+		return time.Time{}, []ValidationError{{Field: key,
+			Message: err.Error()}}
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// dashboardHistory responds with the user dashboard audit log, latest first.
+// It's paginated with limit and offset and filtered by action and since and
+// until time range.
+func (s *Server) dashboardHistory(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	limit := defaultHistoryLimit
+
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			return errorResponse(c, http.StatusBadRequest, "invalid_limit",
+				"limit must be integer from 1 to "+
+					strconv.Itoa(maxHistoryLimit))
+		}
+	}
+
+	var offset int
+
+	if o := c.Query("offset"); o != "" {
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			return errorResponse(c, http.StatusBadRequest, "invalid_offset",
+				"offset must be non-negative integer")
+		}
+	}
+
+	where := goqu.Ex{"dashboard_id": dashboardID}
+
+	if a := c.Query("action"); a != "" {
+		if !auditActions[a] {
+			return errorResponse(c, http.StatusBadRequest, "invalid_action",
+				"unknown audit action")
+		}
+		where["action"] = a
+	}
+
+	since, errs := parseHistoryTime(c, "since")
+	until, untilErrs := parseHistoryTime(c, "until")
+	errs = append(errs, untilErrs...)
+
+	if len(errs) == 0 && !since.IsZero() && !until.IsZero() &&
+		!since.Before(until) {
+		errs = append(errs, ValidationError{Field: "since",
+			Message: "must be before until"})
+	}
+
+	if len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_time_range", "time range is invalid", errs)
+	}
+
+	exps := []goqu.Expression{where}
+
+	if !since.IsZero() {
+		exps = append(exps, goqu.C("at").Gte(since))
+	}
+	if !until.IsZero() {
+		exps = append(exps, goqu.C("at").Lt(until))
+	}
+
+	var id int
+
+	found, err := s.db.Select("id").From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanValContext(c.UserContext(), &id)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	es := []AuditEntry{}
+
+	err = s.db.Select("id", "user_id", "action", "dashboard_id", "at",
+		"details", goqu.COALESCE(goqu.C("ip"), "").As("ip")).
+		From("audit_log").
+		Where(exps...).
+		Order(goqu.C("at").Desc(), goqu.C("id").Desc()).
+		Limit(uint(limit)).Offset(uint(offset)).
+		Executor().ScanStructsContext(c.UserContext(), &es)
+	if err != nil {
+		return internalError(c, "failed to get audit log from DB", err)
+	}
+
+	return c.JSON(AuditEntriesRes{History: es})
+}
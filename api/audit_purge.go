@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// auditPurgeBatch is a width of audit log ID range deleted by one statement.
+// Every batch is a separate short transaction, so huge purge doesn't lock
+// the audit log for long.
+const auditPurgeBatch = 1000
+
+var errAuditPurgeRunning = errors.New("audit log purge is already running")
+
+type AuditPurgeRes struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// purgeAudit deletes audit log entries older than retention period by ID
+// ranges. Only one purge runs at a time.
+func (s *Server) purgeAudit(ctx context.Context) (int64, error) {
+	if !atomic.CompareAndSwapInt32(&s.auditPurging, 0, 1) {
+		return 0, errAuditPurgeRunning
+	}
+	defer atomic.StoreInt32(&s.auditPurging, 0)
+
+	cutoff := time.Now().Add(-s.config.AuditRetention)
+	old := goqu.C("at").Lt(cutoff)
+
+	var ids struct {
+		Min sql.NullInt64 `db:"min_id"`
+		Max sql.NullInt64 `db:"max_id"`
+	}
+
+	_, err := s.db.Select(goqu.MIN("id").As("min_id"),
+		goqu.MAX("id").As("max_id")).From("audit_log").Where(old).
+		Executor().ScanStructContext(ctx, &ids)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ids.Min.Valid {
+		return 0, nil
+	}
+
+	var deleted int64
+
+	for from := ids.Min.Int64; from <= ids.Max.Int64; from += auditPurgeBatch {
+		res, err := s.db.Delete("audit_log").Where(old,
+			goqu.C("id").Gte(from),
+			goqu.C("id").Lt(from+auditPurgeBatch)).
+			Executor().ExecContext(ctx)
+		if err != nil {
+			return deleted, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// pruneAudit purges audit log by the pruner. It's skipped while a manual
+// purge is running, since that purge deletes the same rows.
+func (s *Server) pruneAudit(ctx context.Context) {
+	n, err := s.purgeAudit(ctx)
+	if errors.Is(err, errAuditPurgeRunning) {
+		logger.Infof("[pruner] skipped audit_log purge: %v", err)
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Errorf("[pruner] failed to purge audit_log, %d rows"+
+				" deleted: %v", n, err)
+		}
+		return
+	}
+
+	logger.Infof("[pruner] pruned %d rows from audit_log", n)
+}
+
+// adminPurgeAudit purges audit log on demand.
+func (s *Server) adminPurgeAudit(c *fiber.Ctx) error {
+	if s.config.AuditRetention <= 0 {
+		return errorResponse(c, http.StatusConflict, "audit_retention_disabled",
+			"audit log retention is disabled")
+	}
+
+	n, err := s.purgeAudit(c.UserContext())
+	if err != nil {
+		if errors.Is(err, errAuditPurgeRunning) {
+			return errorResponse(c, http.StatusConflict,
+				"audit_purge_running", err.Error())
+		}
+		return internalError(c, "failed to purge audit log", err)
+	}
+
+	logger.Infof("[admin] purged %d rows from audit_log", n)
+
+	return c.JSON(AuditPurgeRes{Deleted: n})
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+func TestPruneAuditSkipsRunningPurge(t *testing.T) {
+	s, _ := newTestApp(t, "http://127.0.0.1:1", Config{
+		AuditRetention: time.Hour,
+	})
+
+	logs := captureLogs(t, logger.Info)
+
+	// Manual purge is running, so the pruner doesn't touch DB.
+	atomic.StoreInt32(&s.auditPurging, 1)
+
+	s.pruneAudit(context.Background())
+
+	out := logs.String()
+	if strings.Contains(out, "ERROR") {
+		t.Errorf("got error logged on running purge: %s", out)
+	}
+	if !strings.Contains(out, "skipped audit_log purge") {
+		t.Errorf("got logs %q, want skip logged", out)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/tracing"
+)
+
+// authToken returns the request auth token from the configured header or,
+// as a fallback, from the Authorization bearer header.
+func (s *Server) authToken(c *fiber.Ctx) string {
+	if token := c.Get(s.config.AuthTokenHeader); token != "" {
+		return token
+	}
+
+	const bearer = "Bearer "
+
+	h := c.Get(fiber.HeaderAuthorization)
+	if len(h) > len(bearer) && strings.EqualFold(h[:len(bearer)], bearer) {
+		return strings.TrimSpace(h[len(bearer):])
+	}
+
+	return ""
+}
+
+func (s *Server) auth(c *fiber.Ctx) error {
+
+	token := s.authToken(c)
+
+	if token == "" {
+		return errorResponse(c, http.StatusForbidden, "token_absent",
+			"token is absent")
+	}
+
+	ctx, span := tracing.Start(c.UserContext(), "iam.verify")
+	userID, err := s.verifier.Verify(ctx, token)
+	tracing.End(span, err)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			// Request is cancelled before its token is checked, so it isn't
+			// counted as an auth outcome.
+			return internalError(c, "failed to check token", err)
+		case errors.Is(err, core.ErrInvalidToken):
+			s.authMetrics.record(authInvalid, time.Now())
+			return errorResponse(c, http.StatusUnauthorized, "invalid_token",
+				"invalid token")
+		case errors.Is(err, core.ErrTokenServiceUnavailable):
+			s.authMetrics.record(authUnavailable, time.Now())
+			return errorResponse(c, http.StatusInternalServerError,
+				"token_service_unavailable", "unable to check token")
+		default:
+			s.authMetrics.record(authError, time.Now())
+			return internalError(c, "failed to check token", err)
+		}
+	}
+
+	s.authMetrics.record(authValid, time.Now())
+
+	c.Locals("userID", userID)
+
+	return c.Next()
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// IAM auth outcomes. They are the only label values of auth metrics, so
+// metrics cardinality is fixed.
+const (
+	authValid       = "valid"
+	authInvalid     = "invalid"
+	authUnavailable = "unavailable"
+	authError       = "error"
+)
+
+var authResults = []string{authValid, authInvalid, authUnavailable, authError}
+
+const (
+	// authWindow is a sliding window of IAM unavailability rate. It's
+	// counted in buckets of a second.
+	authWindow = time.Minute
+
+	// authAlertMinRequests is min number of auth requests in the window to
+	// warn about unavailability, so single failures don't alert.
+	authAlertMinRequests = 20
+)
+
+type authBucket struct {
+	second      int64
+	total       int
+	unavailable int
+}
+
+// authMetrics counts IAM auth outcomes and warns when share of IAM
+// unavailable outcomes in the sliding window crosses the threshold.
+type authMetrics struct {
+	alertPercent int
+	counters     map[string]*uint64
+
+	mx        sync.Mutex
+	buckets   [int(authWindow / time.Second)]authBucket
+	lastAlert time.Time
+}
+
+// newAuthMetrics creates new authMetrics warning when alertPercent percents
+// of auth requests in the window found IAM unavailable. Zero alertPercent
+// disables warnings.
+func newAuthMetrics(alertPercent int) *authMetrics {
+	m := &authMetrics{
+		alertPercent: alertPercent,
+		counters:     map[string]*uint64{},
+	}
+	for _, r := range authResults {
+		m.counters[r] = new(uint64)
+	}
+	return m
+}
+
+// record counts the auth outcome.
+func (m *authMetrics) record(result string, now time.Time) {
+	atomic.AddUint64(m.counters[result], 1)
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	sec := now.Unix()
+
+	b := &m.buckets[sec%int64(len(m.buckets))]
+	if b.second != sec {
+		*b = authBucket{second: sec}
+	}
+
+	b.total++
+	if result == authUnavailable {
+		b.unavailable++
+	}
+
+	if result != authUnavailable || m.alertPercent <= 0 ||
+		now.Sub(m.lastAlert) < authWindow {
+		return
+	}
+
+	total, unavailable := m.window(sec)
+	if total < authAlertMinRequests ||
+		unavailable*100 < m.alertPercent*total {
+		return
+	}
+
+	m.lastAlert = now
+
+	logger.Warnf("[auth] IAM is unavailable for %d of %d auth requests in"+
+		" the last %s", unavailable, total, authWindow)
+}
+
+// window returns number of all and IAM unavailable auth requests in the
+// window ending at the second. Caller must hold the lock.
+func (m *authMetrics) window(sec int64) (int, int) {
+	var total, unavailable int
+
+	for _, b := range m.buckets {
+		if sec-b.second < int64(len(m.buckets)) {
+			total += b.total
+			unavailable += b.unavailable
+		}
+	}
+
+	return total, unavailable
+}
+
+// unavailableRatio returns share of IAM unavailable auth requests in the
+// window ending now.
+func (m *authMetrics) unavailableRatio(now time.Time) float64 {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	total, unavailable := m.window(now.Unix())
+	if total == 0 {
+		return 0
+	}
+
+	return float64(unavailable) / float64(total)
+}
+
+// write writes the metrics in Prometheus text format.
+func (m *authMetrics) write(b *strings.Builder, now time.Time) {
+	fmt.Fprintf(b, "# HELP iam_auth_total IAM auth requests by outcome.\n")
+	fmt.Fprintf(b, "# TYPE iam_auth_total counter\n")
+	for _, r := range authResults {
+		fmt.Fprintf(b, "iam_auth_total{result=%q} %d\n", r,
+			atomic.LoadUint64(m.counters[r]))
+	}
+
+	fmt.Fprintf(b, "# HELP iam_auth_unavailable_ratio Share of IAM"+
+		" unavailable auth requests in the last %s.\n", authWindow)
+	fmt.Fprintf(b, "# TYPE iam_auth_unavailable_ratio gauge\n")
+	fmt.Fprintf(b, "iam_auth_unavailable_ratio %g\n",
+		m.unavailableRatio(now))
+}
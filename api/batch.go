@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+const maxBatchQueryMetrics = 10
+
+var (
+	cesPeriods = map[string]bool{
+		"1":     true,
+		"300":   true,
+		"1200":  true,
+		"3600":  true,
+		"14400": true,
+		"86400": true,
+	}
+
+	cesFilters = map[string]bool{
+		"average":  true,
+		"max":      true,
+		"min":      true,
+		"sum":      true,
+		"variance": true,
+	}
+)
+
+type (
+	BatchQueryReq       = core.BatchQueryReq
+	BatchQueryMetric    = core.BatchQueryMetric
+	BatchQueryResMetric = core.BatchQueryResMetric
+	BatchQueryRes       = core.BatchQueryRes
+)
+
+// metricKey returns key identifying metric with its dimensions.
+func metricKey(namespace, metricName string, ds []Dimension) string {
+	k := namespace + "|" + metricName
+	for _, d := range ds {
+		k += "|" + d.Name + "=" + d.Value
+	}
+	return k
+}
+
+// errNoDimensions is an error of graph which metric can't be batch queried.
+const errNoDimensions = "graph has no dimensions"
+
+// graphBatches splits indexes of graphs with dimensions to CES batch query
+// sized batches. Graphs without dimensions are skipped since batch query
+// requires them.
+func graphBatches(gs []Graph) [][]int {
+	var batches [][]int
+
+	for i, g := range gs {
+		if len(g.Dimensions) == 0 {
+			continue
+		}
+
+		if len(batches) == 0 ||
+			len(batches[len(batches)-1]) == maxBatchQueryMetrics {
+			batches = append(batches, nil)
+		}
+
+		batches[len(batches)-1] = append(batches[len(batches)-1], i)
+	}
+
+	return batches
+}
+
+// validateBatchQuery checks the request before sending it to CES to avoid
+// wasted upstream calls.
+func validateBatchQuery(r BatchQueryReq) []ValidationError {
+	var errs []ValidationError
+
+	if len(r.Metrics) == 0 {
+		errs = append(errs, ValidationError{Field: "metrics",
+			Message: "must not be empty"})
+	}
+	if len(r.Metrics) > maxBatchQueryMetrics {
+		errs = append(errs, ValidationError{Field: "metrics",
+			Message: fmt.Sprintf("must contain at most %d metrics",
+				maxBatchQueryMetrics)})
+	}
+
+	for i, m := range r.Metrics {
+		field := fmt.Sprintf("metrics[%d]", i)
+		if m.Namespace == "" {
+			errs = append(errs, ValidationError{Field: field + ".namespace",
+				Message: "must not be empty"})
+		}
+		if m.MetricName == "" {
+			errs = append(errs, ValidationError{Field: field + ".metric_name",
+				Message: "must not be empty"})
+		}
+		if len(m.Dimensions) == 0 {
+			errs = append(errs, ValidationError{Field: field + ".dimensions",
+				Message: "must not be empty"})
+		}
+		for j, d := range m.Dimensions {
+			if d.Name == "" || d.Value == "" {
+				errs = append(errs, ValidationError{
+					Field:   fmt.Sprintf("%s.dimensions[%d]", field, j),
+					Message: "name and value must not be empty"})
+			}
+		}
+	}
+
+	if r.From <= 0 {
+		errs = append(errs, ValidationError{Field: "from",
+			Message: "must be positive epoch milliseconds"})
+	}
+	if r.From >= r.To {
+		errs = append(errs, ValidationError{Field: "to",
+			Message: "must be greater than from"})
+	}
+	if !cesPeriods[r.Period] {
+		errs = append(errs, ValidationError{Field: "period",
+			Message: fmt.Sprintf("unsupported period %q", r.Period)})
+	}
+	if !cesFilters[r.Filter] {
+		errs = append(errs, ValidationError{Field: "filter",
+			Message: fmt.Sprintf("unsupported filter %q", r.Filter)})
+	}
+
+	return errs
+}
+
+func (s *Server) cesBatchQuery(c *fiber.Ctx) error {
+
+	var bqr BatchQueryReq
+
+	err := json.Unmarshal(c.Body(), &bqr)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidJSON,
+			"failed to JSON unmarshal batch query")
+	}
+
+	if errs := validateBatchQuery(bqr); len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_batch_query", "batch query is invalid", errs)
+	}
+
+	ctx, cancel := detach(c.UserContext())
+
+	res, err := s.ces.BatchQueryMetricDataRaw(ctx, bqr)
+	if err != nil {
+		cancel()
+		return cesRequestError(c, err)
+	}
+
+	return s.sendCESResponse(c, res, cancel)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxBulkDashboardIDs = 100
+
+type BulkDashboardsRes struct {
+	Dashboards []map[string]interface{} `json:"dashboards"`
+	NotFound   []int                    `json:"not_found"`
+}
+
+// parseIDs parses comma separated list of unique dashboard IDs.
+func parseIDs(ids string) ([]int, error) {
+	var res []int
+	seen := map[int]bool{}
+
+	for _, s := range strings.Split(ids, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+
+	return res, nil
+}
+
+// bulkGetDashboards responds with the user dashboards of the ids in the
+// requested order. Absent and other users dashboards are reported as not
+// found.
+func (s *Server) bulkGetDashboards(c *fiber.Ctx, userID string) error {
+	ids, err := parseIDs(c.Query("ids"))
+	if err != nil || len(ids) == 0 {
+		return errorResponse(c, http.StatusBadRequest, "invalid_ids",
+			"ids must be comma separated list of dashboard IDs")
+	}
+	if len(ids) > maxBulkDashboardIDs {
+		return errorResponse(c, http.StatusBadRequest, "invalid_ids",
+			"ids must contain at most "+strconv.Itoa(maxBulkDashboardIDs)+
+				" dashboard IDs")
+	}
+
+	fields, err := parseFields(c.Query("fields"), dashboardFields,
+		dashboardFields)
+	if err != nil {
+		return fieldsErrorResponse(c, err)
+	}
+
+	var ds []Dashboard
+
+	err = s.readDB.Select(fieldColumns(fields, "id")...).From("dashboard").
+		Where(goqu.Ex{"user_id": userID, "id": ids}).
+		Executor().ScanStructsContext(c.UserContext(), &ds)
+	if err != nil {
+		return internalError(c, "failed to get dashboards from DB", err)
+	}
+
+	byID := make(map[int]Dashboard, len(ds))
+	for _, d := range ds {
+		byID[d.ID] = d
+	}
+
+	res := BulkDashboardsRes{
+		Dashboards: []map[string]interface{}{},
+		NotFound:   []int{},
+	}
+
+	for _, id := range ids {
+		d, ok := byID[id]
+		if !ok {
+			res.NotFound = append(res.NotFound, id)
+			continue
+		}
+		res.Dashboards = append(res.Dashboards,
+			dashboardFieldValues(d, fields))
+	}
+
+	return c.JSON(res)
+}
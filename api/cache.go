@@ -0,0 +1,68 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheItem struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// cache is an in-memory key-value cache with items expiration.
+type cache struct {
+	ttl   time.Duration
+	mx    sync.Mutex
+	items map[string]cacheItem
+}
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{
+		ttl:   ttl,
+		items: map[string]cacheItem{},
+	}
+}
+
+// setTTL sets TTL of items set after the call.
+func (c *cache) setTTL(ttl time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.ttl = ttl
+}
+
+func (c *cache) get(key string) (interface{}, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	i, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(i.expiresAt) {
+		delete(c.items, key)
+		return nil, false
+	}
+
+	return i.value, true
+}
+
+func (c *cache) set(key string, value interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := time.Now()
+
+	for k, i := range c.items {
+		if now.After(i.expiresAt) {
+			delete(c.items, k)
+		}
+	}
+
+	c.items[key] = cacheItem{
+		value:     value,
+		expiresAt: now.Add(c.ttl),
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const cesListMetricsLimit = 1000
+
+type CatalogRes struct {
+	Namespaces []string            `json:"namespaces"`
+	Metrics    map[string][]string `json:"metrics"`
+}
+
+// listCESMetrics lists all CES metrics following the pagination markers.
+func (s *Server) listCESMetrics(ctx context.Context) ([]core.Metric, error) {
+	var ms []core.Metric
+
+	start := ""
+
+	for {
+		lmr, err := s.ces.ListMetrics(ctx, core.ListMetricsParams{
+			Limit: cesListMetricsLimit,
+			Start: start,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ms = append(ms, lmr.Metrics...)
+
+		if len(lmr.Metrics) < cesListMetricsLimit ||
+			lmr.MetaData.Marker == "" || lmr.MetaData.Marker == start {
+			break
+		}
+
+		start = lmr.MetaData.Marker
+	}
+
+	return ms, nil
+}
+
+// SetCatalogCacheTTL sets how long CES metrics catalog is cached. Already
+// cached catalogs keep their expiration.
+func (s *Server) SetCatalogCacheTTL(ttl time.Duration) {
+	s.catalogCache.setTTL(ttl)
+}
+
+func (s *Server) cesCatalog(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	if cr, ok := s.catalogCache.get(userID); ok {
+		return c.JSON(cr)
+	}
+
+	ms, err := s.listCESMetrics(c.UserContext())
+	if err != nil {
+		logger.Warnf("[ces catalog] failed to list CES metrics: %v", err)
+		if errors.Is(err, core.ErrCESCircuitOpen) {
+			return errorResponse(c, http.StatusServiceUnavailable,
+				codeCESCircuitOpen, "CES is unavailable, try again later")
+		}
+		return errorResponse(c, http.StatusBadGateway, codeCESUnavailable,
+			"failed to list CES metrics")
+	}
+
+	names := map[string]map[string]bool{}
+
+	for _, m := range ms {
+		if names[m.Namespace] == nil {
+			names[m.Namespace] = map[string]bool{}
+		}
+		names[m.Namespace][m.MetricName] = true
+	}
+
+	cr := CatalogRes{
+		Namespaces: []string{},
+		Metrics:    map[string][]string{},
+	}
+
+	for ns, mns := range names {
+		cr.Namespaces = append(cr.Namespaces, ns)
+		for mn := range mns {
+			cr.Metrics[ns] = append(cr.Metrics[ns], mn)
+		}
+		sort.Strings(cr.Metrics[ns])
+	}
+
+	sort.Strings(cr.Namespaces)
+
+	s.catalogCache.set(userID, cr)
+
+	return c.JSON(cr)
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sony/gobreaker"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+type CESErrorDetails struct {
+	UpstreamStatus int `json:"upstream_status"`
+}
+
+// logCESRequest logs CES request URL with debug level. Sampled requests are
+// logged with info level to keep some visibility in production.
+func (s *Server) logCESRequest(url string) {
+	if s.cesLogSampler.Sample() {
+		logger.Infof("[ces request] sampled url=%s", s.redactURL(url))
+	} else if logger.Enabled(logger.Debug) {
+		logger.Debugf("[ces request] url=%s", s.redactURL(url))
+	}
+}
+
+func (s *Server) proxyCES(c *fiber.Ctx) error {
+
+	path, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_path",
+			"failed to unescape CES path")
+	}
+
+	err = s.checkCESPath(path)
+	if err != nil {
+		return errorResponse(c, http.StatusForbidden, "ces_path_forbidden",
+			err.Error())
+	}
+
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_query",
+			"failed to parse CES query")
+	}
+
+	errs := normalizeQueryTimeRange(query, s.config.MaxTimeRange)
+	if len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_time_range", "time range is invalid", errs)
+	}
+
+	// Client accepted encodings are forwarded, so the compressed body is
+	// passed through untouched instead of being decoded by the transport.
+	var header http.Header
+	if ae := c.Get(fiber.HeaderAcceptEncoding); ae != "" {
+		header = http.Header{fiber.HeaderAcceptEncoding: {ae}}
+	}
+
+	if s.cesFlight == nil {
+		return s.streamCESGet(c, path, query, header)
+	}
+
+	// Concurrent identical requests, like dashboard widgets of the same
+	// metric, share one upstream call. The shared body is buffered, so
+	// nothing outlives the handler.
+	res, err := s.doSharedCESGet(c.UserContext(), path, query, header)
+	if errors.Is(err, errCESResponseNotShared) {
+		return s.streamCESGet(c, path, query, header)
+	}
+	if err != nil {
+		return cesRequestError(c, err)
+	}
+
+	return s.sendCESResponse(c, res, func() {})
+}
+
+// streamCESGet does CES GET request of the client alone and streams the
+// response body to the client.
+func (s *Server) streamCESGet(c *fiber.Ctx, path string, query url.Values,
+	header http.Header) error {
+
+	// Response body is streamed after the handler returns, so request
+	// context must outlive the handler. It's cancelled when the body is
+	// closed by the server.
+	ctx, cancel := detach(c.UserContext())
+
+	res, err := s.ces.Do(ctx, http.MethodGet, path, query, header, nil)
+	if err != nil {
+		cancel()
+		return cesRequestError(c, err)
+	}
+
+	return s.sendCESResponse(c, res, cancel)
+}
+
+// cesRequestError responds to failed CES request. Requests rejected by the
+// circuit breaker are fast-failed with 503.
+func cesRequestError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, core.ErrCESCircuitOpen) {
+		return errorResponse(c, http.StatusServiceUnavailable,
+			codeCESCircuitOpen, "CES is unavailable, try again later")
+	}
+	return internalError(c, "failed to do http request", err)
+}
+
+// sendCESResponse streams CES response body to the client. The cancel is
+// called when the body is read or the response is rejected. Upstream access
+// denials are normalized to ces_forbidden error.
+func (s *Server) sendCESResponse(c *fiber.Ctx, res *http.Response,
+	cancel context.CancelFunc) error {
+
+	body := cancelCloser{ReadCloser: res.Body, cancel: cancel}
+
+	if res.StatusCode == http.StatusUnauthorized ||
+		res.StatusCode == http.StatusForbidden {
+		body.Close()
+		userID, _ := c.Locals("userID").(string)
+		logger.Warnf("[ces request] upstream denied access: user_id=%s"+
+			" status=%d url=%s", userID, res.StatusCode,
+			s.redactURL(res.Request.URL.String()))
+		return errorDetailsResponse(c, http.StatusForbidden, "ces_forbidden",
+			"CES access denied", CESErrorDetails{
+				UpstreamStatus: res.StatusCode,
+			})
+	}
+
+	copyCESHeaders(c, res.Header)
+
+	c.Status(res.StatusCode)
+
+	if res.ContentLength >= 0 {
+		return c.SendStream(body, int(res.ContentLength))
+	}
+
+	return c.SendStream(body)
+}
+
+// cesHeaders are CES response headers passed through to the client.
+// Content-Length is set from the streamed body size.
+var cesHeaders = []string{
+	fiber.HeaderContentType,
+	fiber.HeaderContentEncoding,
+	fiber.HeaderCacheControl,
+}
+
+// hopByHopHeaders are connection specific headers which must not be
+// forwarded by proxies.
+var hopByHopHeaders = map[string]bool{
+	fiber.HeaderConnection:         true,
+	fiber.HeaderKeepAlive:          true,
+	fiber.HeaderProxyAuthenticate:  true,
+	fiber.HeaderProxyAuthorization: true,
+	fiber.HeaderTE:                 true,
+	fiber.HeaderTrailer:            true,
+	fiber.HeaderTransferEncoding:   true,
+	fiber.HeaderUpgrade:            true,
+}
+
+// copyCESHeaders copies passed through CES response headers to the client
+// response, skipping hop-by-hop ones including listed in Connection header.
+func copyCESHeaders(c *fiber.Ctx, h http.Header) {
+	hopByHop := map[string]bool{}
+	for _, v := range h.Values(fiber.HeaderConnection) {
+		for _, k := range strings.Split(v, ",") {
+			hopByHop[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+
+	for _, k := range cesHeaders {
+		if hopByHopHeaders[k] || hopByHop[k] {
+			continue
+		}
+		if v := h.Get(k); v != "" {
+			c.Set(k, v)
+		}
+	}
+}
+
+// newCESBreaker creates circuit breaker of CES region. It's named by CES
+// host, so every region has its own breaker.
+func newCESBreaker(config Config) *gobreaker.TwoStepCircuitBreaker {
+	name := config.CESAPI
+	if u, err := url.Parse(config.CESAPI); err == nil {
+		name = u.Host
+	}
+
+	failures := uint32(config.CESBreakerFailures)
+
+	return gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+		Name:    name,
+		Timeout: config.CESBreakerCooldown,
+		ReadyToTrip: func(cnt gobreaker.Counts) bool {
+			return cnt.ConsecutiveFailures >= failures
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			logger.Warnf("[ces breaker] %s state changed from %s to %s",
+				name, from, to)
+		},
+	})
+}
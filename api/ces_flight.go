@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// cesFlightRes is CES response shared by concurrent identical requests.
+type cesFlightRes struct {
+	status  int
+	header  http.Header
+	body    []byte
+	request *http.Request
+}
+
+// response returns new http.Response of the shared response. Header is
+// shared between waiters, so it must not be modified.
+func (r *cesFlightRes) response() *http.Response {
+	return &http.Response{
+		StatusCode:    r.status,
+		Header:        r.header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+		Request:       r.request,
+	}
+}
+
+// flightWaiters counts requests waiting for calls of a key. Cancel cancels
+// the call in flight.
+type flightWaiters struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+// errCESResponseNotShared is returned to waiters of shared call which
+// response body exceeds the shared body limit.
+var errCESResponseNotShared = errors.New("CES response is too large to share")
+
+// cesFlight is a singleflight group of CES requests. Shared call is aborted
+// when all its waiters are gone, so upstream connection isn't held for
+// nobody.
+type cesFlight struct {
+	group singleflight.Group
+
+	// bodyLimit is max size of shared response body. It's buffered for all
+	// waiters, so larger responses aren't shared.
+	bodyLimit int64
+
+	mx      sync.Mutex
+	waiters map[string]*flightWaiters
+}
+
+func newCESFlight(bodyLimit int) *cesFlight {
+	return &cesFlight{
+		bodyLimit: int64(bodyLimit),
+		waiters:   map[string]*flightWaiters{},
+	}
+}
+
+func (f *cesFlight) join(key string) *flightWaiters {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	w, ok := f.waiters[key]
+	if !ok {
+		w = &flightWaiters{}
+		f.waiters[key] = w
+	}
+	w.n++
+
+	return w
+}
+
+// leave removes the waiter. The last waiter cancels the call in flight, and
+// the key is forgotten, so next requests don't join the cancelled call.
+func (f *cesFlight) leave(key string, w *flightWaiters) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	w.n--
+	if w.n > 0 {
+		return
+	}
+
+	if w.cancel != nil {
+		w.cancel()
+	}
+	f.group.Forget(key)
+	delete(f.waiters, key)
+}
+
+// start registers cancel of the call started by the waiters. The call is
+// cancelled right away if the waiters are already gone.
+func (f *cesFlight) start(w *flightWaiters, cancel context.CancelFunc) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	w.cancel = cancel
+	if w.n == 0 {
+		cancel()
+	}
+}
+
+// cesFlightKey is a key of CES GET request deduplication. Unsigned URL is
+// used since signatures include request time. Accepted encodings are part of
+// the key since the body is passed through compressed.
+func (s *Server) cesFlightKey(path string, query url.Values,
+	acceptEncoding string) (string, error) {
+
+	u, err := s.ces.URLOf(path, query)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String() + "\n" + acceptEncoding, nil
+}
+
+// doSharedCESGet does CES GET request sharing one upstream call between
+// concurrent identical requests. The response body is read fully, so it can
+// be fanned out to all waiters. Errors are returned to all waiters of the
+// call and aren't cached: the next request does new call. Response body
+// exceeding the flight body limit is dropped with errCESResponseNotShared,
+// so waiters do their own streamed requests instead of buffering it.
+func (s *Server) doSharedCESGet(ctx context.Context, path string,
+	query url.Values, header http.Header) (*http.Response, error) {
+
+	key, err := s.cesFlightKey(path, query,
+		header.Get(fiber.HeaderAcceptEncoding))
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.cesFlight.join(key)
+	defer s.cesFlight.leave(key, w)
+
+	ch := s.cesFlight.group.DoChan(key, func() (interface{}, error) {
+		// Shared call isn't cancelled with the request which started it,
+		// since other requests wait for it too. It's cancelled when all
+		// waiters are gone.
+		ctx, cancel := detach(ctx)
+		defer cancel()
+
+		s.cesFlight.start(w, cancel)
+
+		res, err := s.ces.Do(ctx, http.MethodGet, path, query, header, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		limit := s.cesFlight.bodyLimit
+		if res.ContentLength > limit {
+			return nil, errCESResponseNotShared
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(body)) > limit {
+			return nil, errCESResponseNotShared
+		}
+
+		return &cesFlightRes{
+			status:  res.StatusCode,
+			header:  res.Header,
+			body:    body,
+			request: res.Request,
+		}, nil
+	})
+
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(*cesFlightRes).response(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// waitFlightWaiters waits until n requests wait for shared CES calls.
+func waitFlightWaiters(t *testing.T, s *Server, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		s.cesFlight.mx.Lock()
+		waiting := 0
+		for _, w := range s.cesFlight.waiters {
+			waiting += w.n
+		}
+		s.cesFlight.mx.Unlock()
+
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d waiters, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type testRes struct {
+	status int
+	body   string
+	err    error
+}
+
+// doConcurrently does n identical requests to the app concurrently.
+func doConcurrently(app *fiber.App, n int, target string) <-chan testRes {
+
+	results := make(chan testRes, n)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := app.Test(newTestRequest(http.MethodGet, target), -1)
+			if err != nil {
+				results <- testRes{err: err}
+				return
+			}
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			results <- testRes{status: res.StatusCode, body: string(body),
+				err: err}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+func TestSharedCESGetOneUpstreamCall(t *testing.T) {
+	const (
+		n    = 5
+		body = `{"metrics":[{"metric_name":"cpu_util"}]}`
+	)
+
+	var calls int32
+	release := make(chan struct{})
+
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}))
+	defer ces.Close()
+
+	s, app := newTestApp(t, ces.URL, Config{CESSharedBodyLimit: 1024})
+
+	results := doConcurrently(app, n, "/ces/V1.0/metrics?namespace=SYS.ECS")
+
+	waitFlightWaiters(t, s, n)
+	close(release)
+
+	for r := range results {
+		if r.err != nil {
+			t.Fatalf("request failed: %v", r.err)
+		}
+		if r.status != http.StatusOK || r.body != body {
+			t.Errorf("got %d %s, want %d %s", r.status, r.body,
+				http.StatusOK, body)
+		}
+	}
+
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Errorf("got %d upstream calls, want 1", c)
+	}
+}
+
+func TestSharedCESGetErrorToAllWaiters(t *testing.T) {
+	const n = 4
+
+	var calls int32
+	release := make(chan struct{})
+
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				w.Write([]byte(`{}`))
+				return
+			}
+			<-release
+			// Connection is dropped without response, so the shared call
+			// fails with transport error.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}))
+	defer ces.Close()
+
+	s, app := newTestApp(t, ces.URL, Config{CESSharedBodyLimit: 1024})
+
+	results := doConcurrently(app, n, "/ces/V1.0/metrics")
+
+	waitFlightWaiters(t, s, n)
+	close(release)
+
+	for r := range results {
+		if r.err != nil {
+			t.Fatalf("request failed: %v", r.err)
+		}
+		if r.status != http.StatusInternalServerError ||
+			!strings.Contains(r.body, codeInternal) {
+			t.Errorf("got %d %s, want %d internal error", r.status, r.body,
+				http.StatusInternalServerError)
+		}
+	}
+
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Errorf("got %d upstream calls, want 1", c)
+	}
+
+	// Error isn't cached: next request does new call.
+	res, err := app.Test(newTestRequest(http.MethodGet, "/ces/V1.0/metrics"),
+		-1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	res.Body.Close()
+
+	if c := atomic.LoadInt32(&calls); res.StatusCode != http.StatusOK ||
+		c != 2 {
+		t.Errorf("got status %d after %d calls, want %d after 2",
+			res.StatusCode, c, http.StatusOK)
+	}
+}
+
+func TestSharedCESGetLargeResponseStreamed(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		name := "content length"
+		if chunked {
+			name = "chunked"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			const n = 3
+
+			body := strings.Repeat("x", 4096)
+
+			var calls int32
+			release := make(chan struct{})
+
+			ces := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(&calls, 1) == 1 {
+						<-release
+					}
+					if chunked {
+						w.Write([]byte(body[:100]))
+						w.(http.Flusher).Flush()
+						w.Write([]byte(body[100:]))
+						return
+					}
+					w.Header().Set("Content-Length", "4096")
+					w.Write([]byte(body))
+				}))
+			defer ces.Close()
+
+			s, app := newTestApp(t, ces.URL, Config{CESSharedBodyLimit: 1024})
+
+			results := doConcurrently(app, n, "/ces/V1.0/metric-data")
+
+			waitFlightWaiters(t, s, n)
+			close(release)
+
+			for r := range results {
+				if r.err != nil {
+					t.Fatalf("request failed: %v", r.err)
+				}
+				if r.status != http.StatusOK || r.body != body {
+					t.Errorf("got status %d and %d bytes, want %d and %d",
+						r.status, len(r.body), http.StatusOK, len(body))
+				}
+			}
+
+			// Shared call is dropped and every request streams its own.
+			if c := atomic.LoadInt32(&calls); c != n+1 {
+				t.Errorf("got %d upstream calls, want %d", c, n+1)
+			}
+		})
+	}
+}
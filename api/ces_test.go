@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyCESHeaders(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"metrics":[]}`))
+	zw.Close()
+
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if ae := r.Header.Get("Accept-Encoding"); ae != "gzip" {
+				t.Errorf("got Accept-Encoding %q, want gzip", ae)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("X-Internal", "upstream")
+			w.Write(gz.Bytes())
+		}))
+	defer ces.Close()
+
+	_, app := newTestApp(t, ces.URL, Config{})
+
+	r := newTestRequest(http.MethodGet, "/ces/V1.0/metrics")
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := app.Test(r, -1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	for k, want := range map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+		"Cache-Control":    "max-age=60",
+		"X-Internal":       "",
+	} {
+		if got := res.Header.Get(k); got != want {
+			t.Errorf("got %s %q, want %q", k, got, want)
+		}
+	}
+
+	if !bytes.Equal(body, gz.Bytes()) {
+		t.Errorf("compressed body isn't passed through untouched")
+	}
+}
+
+func TestCopyCESHeadersSkipsConnectionListed(t *testing.T) {
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Connection", "Cache-Control")
+			w.Write([]byte(`{}`))
+		}))
+	defer ces.Close()
+
+	_, app := newTestApp(t, ces.URL, Config{})
+
+	res, err := app.Test(newTestRequest(http.MethodGet, "/ces/V1.0/metrics"),
+		-1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+	res.Body.Close()
+
+	if got := res.Header.Get("Cache-Control"); got != "" {
+		t.Errorf("got Cache-Control %q listed in Connection, want none", got)
+	}
+	if got := res.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+const (
+	graphOK      = "ok"
+	graphMissing = "missing"
+	graphError   = "error"
+
+	checkConcurrency = 4
+
+	// checkCacheTTL is how long dashboard check results are cached, so
+	// repeated checks don't hammer CES.
+	checkCacheTTL = 30 * time.Second
+)
+
+// GraphCheck is a status of the graph metric in CES.
+type GraphCheck struct {
+	GraphID    string      `json:"graph_id,omitempty"`
+	Namespace  string      `json:"namespace"`
+	MetricName string      `json:"metric_name"`
+	Dimensions []Dimension `json:"dimensions,omitempty"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+}
+
+type DashboardCheckRes struct {
+	Graphs    []GraphCheck `json:"graphs"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// checkGraphs checks graphs metrics exist in CES. Metrics are listed
+// concurrently with bounded concurrency.
+func (s *Server) checkGraphs(ctx context.Context, gs []Graph) []GraphCheck {
+	cs := make([]GraphCheck, len(gs))
+
+	for i, g := range gs {
+		cs[i] = GraphCheck{
+			GraphID:    g.ID,
+			Namespace:  g.Namespace,
+			MetricName: g.MetricName,
+			Dimensions: g.Dimensions,
+			Status:     graphOK,
+		}
+	}
+
+	exist, errs := core.FanOut(ctx, gs, checkConcurrency,
+		func(ctx context.Context, g Graph) (bool, error) {
+			res, err := s.ces.ListMetrics(ctx, core.ListMetricsParams{
+				Namespace:  g.Namespace,
+				MetricName: g.MetricName,
+				Dimensions: g.Dimensions,
+				Limit:      1,
+			})
+			if err != nil {
+				return false, err
+			}
+
+			return len(res.Metrics) > 0, nil
+		})
+
+	for i, err := range errs {
+		switch {
+		case err != nil:
+			cs[i].Status = graphError
+			cs[i].Error = err.Error()
+		case !exist[i]:
+			cs[i].Status = graphMissing
+		}
+	}
+
+	return cs
+}
+
+// checkDashboard checks metrics of the user dashboard graphs still exist in
+// CES. Results are cached briefly per dashboard version.
+func (s *Server) checkDashboard(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	ctx := c.UserContext()
+
+	var d struct {
+		Graphs    json.RawMessage `db:"graphs"`
+		UpdatedAt time.Time       `db:"updated_at"`
+	}
+
+	found, err := s.db.Select("graphs", "updated_at").From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanStructContext(ctx, &d)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	// Dashboard is owned by the user, so its ID with version is enough to
+	// key results. Updated dashboard is checked again.
+	key := strconv.Itoa(dashboardID) + ":" +
+		strconv.FormatInt(d.UpdatedAt.UnixNano(), 10)
+
+	if res, ok := s.checkCache.get(key); ok {
+		return c.JSON(res)
+	}
+
+	var gs []Graph
+
+	if !isNullJSON(d.Graphs) {
+		err = json.Unmarshal(d.Graphs, &gs)
+		if err != nil {
+			return errorResponse(c, http.StatusUnprocessableEntity,
+				codeInvalidGraphs, "dashboard graphs are invalid")
+		}
+	}
+
+	res := DashboardCheckRes{
+		Graphs:    s.checkGraphs(ctx, gs),
+		CheckedAt: time.Now(),
+	}
+
+	// Results of the cancelled check are incomplete, so they aren't cached.
+	if ctx.Err() != nil {
+		return internalError(c, "failed to check dashboard", ctx.Err())
+	}
+
+	s.checkCache.set(key, res)
+
+	return c.JSON(res)
+}
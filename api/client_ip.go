@@ -0,0 +1,26 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type clientIPKey struct{}
+
+// resolveClientIP passes the request client IP to handlers with user context,
+// so it's available in code having only the context, e.g. audit. Client IP
+// is taken from X-Forwarded-For only if the request came from a trusted
+// proxy, otherwise it's the connection remote address and can't be spoofed
+// with the header.
+func resolveClientIP(c *fiber.Ctx) error {
+	c.SetUserContext(context.WithValue(c.UserContext(), clientIPKey{},
+		c.IP()))
+	return c.Next()
+}
+
+// clientIP returns client IP of the request context or empty string.
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	csvColNamespace  = "namespace"
+	csvColMetricName = "metric_name"
+	csvColType       = "type"
+	csvColTitle      = "title"
+	csvColDimensions = "dimensions"
+)
+
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// parseGraphsCSV parses graphs from CSV with header row. Required columns are
+// namespace, metric_name and type, optional are title and dimensions. The
+// dimensions are semicolon separated name=value pairs. Every invalid row is
+// reported in returned row errors.
+func parseGraphsCSV(r io.Reader) ([]Graph, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, []RowError{{Row: 1, Message: "header is absent"}}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	var rowErrs []RowError
+
+	for _, col := range []string{csvColNamespace, csvColMetricName,
+		csvColType} {
+		if _, ok := cols[col]; !ok {
+			rowErrs = append(rowErrs, RowError{Row: 1,
+				Message: "column " + col + " is absent"})
+		}
+	}
+	if len(rowErrs) > 0 {
+		return nil, rowErrs, nil
+	}
+
+	field := func(rec []string, col string) string {
+		i, ok := cols[col]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var gs []Graph
+
+	for row := 2; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if pe, ok := err.(*csv.ParseError); ok {
+				rowErrs = append(rowErrs, RowError{Row: pe.Line,
+					Message: pe.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+
+		g := Graph{
+			Title:      field(rec, csvColTitle),
+			Type:       field(rec, csvColType),
+			Namespace:  field(rec, csvColNamespace),
+			MetricName: field(rec, csvColMetricName),
+		}
+
+		var msgs []string
+
+		if g.Namespace == "" {
+			msgs = append(msgs, "namespace is empty")
+		}
+		if g.MetricName == "" {
+			msgs = append(msgs, "metric_name is empty")
+		}
+		if !graphTypes[g.Type] {
+			msgs = append(msgs, fmt.Sprintf("unknown type %q", g.Type))
+		}
+
+		if ds := field(rec, csvColDimensions); ds != "" {
+			for _, d := range strings.Split(ds, ";") {
+				nv := strings.SplitN(d, "=", 2)
+				if len(nv) != 2 || strings.TrimSpace(nv[0]) == "" {
+					msgs = append(msgs, fmt.Sprintf("invalid dimension %q", d))
+					continue
+				}
+				g.Dimensions = append(g.Dimensions, Dimension{
+					Name:  strings.TrimSpace(nv[0]),
+					Value: strings.TrimSpace(nv[1]),
+				})
+			}
+		}
+
+		if len(msgs) > 0 {
+			rowErrs = append(rowErrs, RowError{Row: row,
+				Message: strings.Join(msgs, ", ")})
+			continue
+		}
+
+		gs = append(gs, g)
+	}
+
+	return gs, rowErrs, nil
+}
+
+func (s *Server) createDashboardFromCSV(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	name, err := validateName(c.FormValue("name"))
+	if err != nil {
+		return nameErrorResponse(c, err)
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_csv",
+			"CSV file is absent")
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return internalError(c, "failed to open CSV file", err)
+	}
+
+	defer f.Close()
+
+	gs, rowErrs, err := parseGraphsCSV(f)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_csv",
+			"failed to read CSV file")
+	}
+	if len(rowErrs) > 0 {
+		return errorDetailsResponse(c, http.StatusUnprocessableEntity,
+			"invalid_csv", "CSV file has invalid rows", rowErrs)
+	}
+
+	if gs == nil {
+		gs = []Graph{}
+	}
+
+	graphs, err := json.Marshal(gs)
+	if err != nil {
+		return internalError(c, "failed to JSON marshal graphs", err)
+	}
+
+	err = s.checkGraphsSize(graphs)
+	if err != nil {
+		return errorResponse(c, http.StatusRequestEntityTooLarge,
+			codeGraphsTooLarge, err.Error())
+	}
+
+	errs, err := s.validateDashboard(c.UserContext(), userID, Dashboard{
+		Name:   name,
+		Graphs: graphs,
+	})
+	if err != nil {
+		return internalError(c, "failed to validate dashboard", err)
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	id, err := s.insertDashboard(c.UserContext(), userID, Dashboard{
+		Name:   name,
+		Graphs: graphs,
+	})
+	if err != nil {
+		return internalError(c, "failed to insert dashboard to db", err)
+	}
+
+	return c.JSON(AddDashboardsRes{ID: id})
+}
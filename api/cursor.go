@@ -0,0 +1,125 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+const (
+	cursorNext = "next"
+	cursorPrev = "prev"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is a keyset pagination position: the page continues after (or
+// before for prev direction) the dashboard with the ordering key.
+type cursor struct {
+	ID        int    `json:"id"`
+	Favorite  bool   `json:"fav,omitempty"`
+	SortOrder int    `json:"so,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Direction string `json:"dir"`
+}
+
+// dashboardCursor returns cursor of the dashboard position.
+func dashboardCursor(d Dashboard, direction string) cursor {
+	return cursor{
+		ID:        d.ID,
+		Favorite:  d.IsFavorite,
+		SortOrder: d.SortOrder,
+		Name:      d.Name,
+		Direction: direction,
+	}
+}
+
+// dashboardsOrder is a dashboards list order: favorites first, then sort
+// order, name and ID to make it total.
+func dashboardsOrder(direction string) []exp.OrderedExpression {
+	if direction == cursorPrev {
+		return []exp.OrderedExpression{goqu.C("is_favorite").Asc(),
+			goqu.C("sort_order").Desc(), goqu.C("name").Desc(),
+			goqu.C("id").Desc()}
+	}
+	return []exp.OrderedExpression{goqu.C("is_favorite").Desc(),
+		goqu.C("sort_order").Asc(), goqu.C("name").Asc(),
+		goqu.C("id").Asc()}
+}
+
+// keyset returns condition of dashboards following the cursor in the
+// cursor direction of dashboards order.
+func (c cursor) keyset() exp.Expression {
+	if c.Direction == cursorPrev {
+		return goqu.Or(
+			goqu.C("is_favorite").Gt(c.Favorite),
+			goqu.And(goqu.C("is_favorite").Eq(c.Favorite), goqu.Or(
+				goqu.C("sort_order").Lt(c.SortOrder),
+				goqu.And(goqu.C("sort_order").Eq(c.SortOrder), goqu.Or(
+					goqu.C("name").Lt(c.Name),
+					goqu.And(goqu.C("name").Eq(c.Name),
+						goqu.C("id").Lt(c.ID)))))))
+	}
+	return goqu.Or(
+		goqu.C("is_favorite").Lt(c.Favorite),
+		goqu.And(goqu.C("is_favorite").Eq(c.Favorite), goqu.Or(
+			goqu.C("sort_order").Gt(c.SortOrder),
+			goqu.And(goqu.C("sort_order").Eq(c.SortOrder), goqu.Or(
+				goqu.C("name").Gt(c.Name),
+				goqu.And(goqu.C("name").Eq(c.Name),
+					goqu.C("id").Gt(c.ID)))))))
+}
+
+// encodeCursor encodes cursor as base64 JSON signed with HMAC, so clients can't
+// tamper it.
+func (s *Server) encodeCursor(c cursor) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.cursorMAC(payload))
+}
+
+func (s *Server) decodeCursor(str string) (cursor, error) {
+	var c cursor
+
+	parts := strings.Split(str, ".")
+	if len(parts) != 2 {
+		return c, errInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	if !hmac.Equal(mac, s.cursorMAC(payload)) {
+		return c, errInvalidCursor
+	}
+
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	if c.Direction != cursorNext && c.Direction != cursorPrev {
+		return c, errInvalidCursor
+	}
+
+	return c, nil
+}
+
+func (s *Server) cursorMAC(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.cursorSecret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+const (
+	defaultDataWindow = time.Hour
+	defaultDataPeriod = "300"
+	defaultDataFilter = "average"
+
+	// dataBucket aligns ends of data windows, so dashboard opens within a
+	// bucket get the same data and share the cache.
+	dataBucket = time.Minute
+
+	dataConcurrency = 4
+)
+
+// GraphData are datapoints of the graph metric.
+type GraphData struct {
+	GraphID    string           `json:"graph_id,omitempty"`
+	Namespace  string           `json:"namespace"`
+	MetricName string           `json:"metric_name"`
+	Dimensions []Dimension      `json:"dimensions,omitempty"`
+	Unit       string           `json:"unit,omitempty"`
+	Datapoints []core.Datapoint `json:"datapoints"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type DashboardDataRes struct {
+	From   int64       `json:"from"`
+	To     int64       `json:"to"`
+	Period string      `json:"period"`
+	Filter string      `json:"filter"`
+	Graphs []GraphData `json:"graphs"`
+}
+
+// graphsData fetches datapoints of graphs metrics in CES batches
+// concurrently with bounded concurrency.
+func (s *Server) graphsData(ctx context.Context, gs []Graph,
+	q BatchQueryReq) []GraphData {
+
+	gds := make([]GraphData, len(gs))
+
+	for i, g := range gs {
+		gds[i] = GraphData{
+			GraphID:    g.ID,
+			Namespace:  g.Namespace,
+			MetricName: g.MetricName,
+			Dimensions: g.Dimensions,
+			Datapoints: []core.Datapoint{},
+		}
+
+		if len(g.Dimensions) == 0 {
+			gds[i].Error = errNoDimensions
+		}
+	}
+
+	batches := graphBatches(gs)
+
+	metrics, errs := core.FanOut(ctx, batches, dataConcurrency,
+		func(ctx context.Context, b []int) (
+			map[string]BatchQueryResMetric, error) {
+
+			bqr := q
+			bqr.Metrics = nil
+
+			for _, i := range b {
+				bqr.Metrics = append(bqr.Metrics, BatchQueryMetric{
+					Namespace:  gs[i].Namespace,
+					MetricName: gs[i].MetricName,
+					Dimensions: gs[i].Dimensions,
+				})
+			}
+
+			res, err := s.ces.BatchQueryMetricData(ctx, bqr)
+			if err != nil {
+				return nil, err
+			}
+
+			ms := map[string]BatchQueryResMetric{}
+			for _, m := range res.Metrics {
+				ms[metricKey(m.Namespace, m.MetricName, m.Dimensions)] = m
+			}
+
+			return ms, nil
+		})
+
+	for bi, b := range batches {
+		for _, i := range b {
+			if errs[bi] != nil {
+				gds[i].Error = errs[bi].Error()
+				continue
+			}
+			m, ok := metrics[bi][metricKey(gs[i].Namespace,
+				gs[i].MetricName, gs[i].Dimensions)]
+			if !ok {
+				continue
+			}
+			gds[i].Unit = m.Unit
+			if m.Datapoints != nil {
+				gds[i].Datapoints = m.Datapoints
+			}
+		}
+	}
+
+	return gds
+}
+
+// hasCESErrors reports whether data of some graphs failed to be fetched.
+// Such data isn't cached, so CES failures aren't served after recovery.
+func hasCESErrors(gds []GraphData) bool {
+	for _, gd := range gds {
+		if gd.Error != "" && gd.Error != errNoDimensions {
+			return true
+		}
+	}
+	return false
+}
+
+// dashboardData responds with datapoints of all the user dashboard graphs
+// for the window ending at the current minute. Data is cached per dashboard
+// version and window if the cache is enabled.
+func (s *Server) dashboardData(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	window := defaultDataWindow
+	period := c.Query("period", defaultDataPeriod)
+	filter := c.Query("filter", defaultDataFilter)
+
+	var errs []ValidationError
+
+	if w := c.Query("window"); w != "" {
+		window, err = time.ParseDuration(w)
+		if err != nil || window <= 0 {
+			errs = append(errs, ValidationError{Field: "window",
+				Message: "must be positive duration"})
+		} else if s.config.MaxTimeRange > 0 &&
+			window > s.config.MaxTimeRange {
+			errs = append(errs, ValidationError{Field: "window",
+				Message: fmt.Sprintf("must not exceed %s",
+					s.config.MaxTimeRange)})
+		}
+	}
+	if !cesPeriods[period] {
+		errs = append(errs, ValidationError{Field: "period",
+			Message: fmt.Sprintf("unsupported period %q", period)})
+	}
+	if !cesFilters[filter] {
+		errs = append(errs, ValidationError{Field: "filter",
+			Message: fmt.Sprintf("unsupported filter %q", filter)})
+	}
+
+	if len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_data_query", "data query is invalid", errs)
+	}
+
+	ctx := c.UserContext()
+
+	var d struct {
+		Graphs    json.RawMessage `db:"graphs"`
+		UpdatedAt time.Time       `db:"updated_at"`
+	}
+
+	found, err := s.readDB.Select("graphs", "updated_at").From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanStructContext(ctx, &d)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	to := time.Now().Truncate(dataBucket)
+	from := to.Add(-window)
+
+	// Dashboard version is a part of the key, so data of updated dashboard
+	// isn't served even if invalidation is missed, e.g. it's updated by
+	// another instance.
+	key := fmt.Sprintf("%d|%d|%s|%s|%d", d.UpdatedAt.UnixNano(),
+		window, period, filter, to.Unix())
+
+	if s.dataCache != nil {
+		if res, ok := s.dataCache.get(dashboardID, key); ok {
+			return c.JSON(res)
+		}
+	}
+
+	var gs []Graph
+
+	if !isNullJSON(d.Graphs) {
+		err = json.Unmarshal(d.Graphs, &gs)
+		if err != nil {
+			return errorResponse(c, http.StatusUnprocessableEntity,
+				codeInvalidGraphs, "dashboard graphs are invalid")
+		}
+	}
+
+	res := DashboardDataRes{
+		From:   from.UnixNano() / int64(time.Millisecond),
+		To:     to.UnixNano() / int64(time.Millisecond),
+		Period: period,
+		Filter: filter,
+	}
+
+	res.Graphs = s.graphsData(ctx, gs, BatchQueryReq{
+		From:   res.From,
+		To:     res.To,
+		Period: period,
+		Filter: filter,
+	})
+
+	// Data of the cancelled request is incomplete, so it isn't cached.
+	if ctx.Err() != nil {
+		return internalError(c, "failed to get dashboard data", ctx.Err())
+	}
+
+	if s.dataCache != nil && !hasCESErrors(res.Graphs) {
+		s.dataCache.set(dashboardID, key, res)
+	}
+
+	return c.JSON(res)
+}
@@ -0,0 +1,399 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	errDashboardNotFound = errors.New("dashboard not found")
+	errGraphNotFound     = errors.New("graph not found")
+)
+
+// rawGraph is a graph with all its fields preserved as is.
+type rawGraph map[string]json.RawMessage
+
+type GraphsRes struct {
+	Graphs []rawGraph `json:"graphs"`
+}
+
+type graphAuditDetails struct {
+	Op      string `json:"op"`
+	GraphID string `json:"graph_id"`
+}
+
+type AddGraphRes struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+}
+
+func newGraphID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isEmptyGraphs reports whether graphs are absent, null or empty array.
+func isEmptyGraphs(graphs json.RawMessage) bool {
+	if isNullJSON(graphs) {
+		return true
+	}
+	var gs []json.RawMessage
+	return json.Unmarshal(graphs, &gs) == nil && len(gs) == 0
+}
+
+// assignGraphIDs sets IDs to graphs without them, existing IDs are kept.
+// Assigned IDs are derived from graph position and content, so the same
+// graphs always get the same IDs. Absent or null graphs are stored as empty
+// array, so reads are consistent.
+func assignGraphIDs(graphs json.RawMessage) (json.RawMessage, error) {
+	if isNullJSON(graphs) {
+		return json.RawMessage("[]"), nil
+	}
+
+	gs, err := parseRawGraphs(graphs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	missing := false
+
+	for _, g := range gs {
+		if id := g.id(); id != "" {
+			ids[id] = true
+		} else {
+			missing = true
+		}
+	}
+
+	if !missing {
+		return graphs, nil
+	}
+
+	for i, g := range gs {
+		if g.id() != "" {
+			continue
+		}
+
+		content, err := json.Marshal(g)
+		if err != nil {
+			return nil, err
+		}
+
+		seed := append([]byte(strconv.Itoa(i)+":"), content...)
+
+		// Collision with existing ID is resolved by rehashing.
+		for {
+			sum := sha256.Sum256(seed)
+			id := hex.EncodeToString(sum[:8])
+			if !ids[id] {
+				ids[id] = true
+				g.setID(id)
+				break
+			}
+			seed = sum[:]
+		}
+	}
+
+	return json.Marshal(gs)
+}
+
+func (g rawGraph) id() string {
+	var id string
+	json.Unmarshal(g["id"], &id)
+	return id
+}
+
+func (g rawGraph) setID(id string) {
+	g["id"], _ = json.Marshal(id)
+}
+
+func findGraph(gs []rawGraph, id string) int {
+	for i, g := range gs {
+		if g.id() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func parseRawGraphs(graphs []byte) ([]rawGraph, error) {
+	var gs []rawGraph
+	if len(graphs) == 0 {
+		return gs, nil
+	}
+	err := json.Unmarshal(graphs, &gs)
+	return gs, err
+}
+
+// modifyGraphs locks the user dashboard row, modifies its graphs with the fn
+// and saves them back within one transaction. The modification is recorded to
+// the audit log with the details.
+func (s *Server) modifyGraphs(ctx context.Context, userID string,
+	dashboardID int, details interface{},
+	fn func([]rawGraph) ([]rawGraph, error)) error {
+
+	err := withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+		var d Dashboard
+
+		found, err := tx.Select("graphs", "layout").From("dashboard").
+			Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+			ForUpdate(exp.Wait).Executor().ScanStructContext(ctx, &d)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errDashboardNotFound
+		}
+
+		gs, err := parseRawGraphs(d.Graphs)
+		if err != nil {
+			return err
+		}
+
+		gs, err = fn(gs)
+		if err != nil {
+			return err
+		}
+
+		if gs == nil {
+			gs = []rawGraph{}
+		}
+
+		graphs, err := json.Marshal(gs)
+		if err != nil {
+			return err
+		}
+
+		layout, err := pruneLayout(d.Layout, graphs)
+		if err != nil {
+			return err
+		}
+
+		err = s.checkGraphsSize(graphs)
+		if err != nil {
+			return err
+		}
+
+		errs := validateGraphs(graphs, currentGraphsVersion, s.config.MaxGraphs)
+		if len(errs) > 0 {
+			return validationErrors(errs)
+		}
+
+		_, err = tx.Update("dashboard").Set(goqu.Record{
+			"graphs":     goqu.L("?::jsonb", string(graphs)),
+			"layout":     jsonbOrNull(layout),
+			"updated_at": goqu.L("now()"),
+		}).Where(goqu.Ex{"id": dashboardID}).Executor().ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		audit(ctx, tx, userID, auditUpdate, dashboardID, details)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.dashboardChanged(eventUpdated, dashboardID)
+
+	return nil
+}
+
+func (s *Server) graphsError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errDashboardNotFound):
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			err.Error())
+	case errors.Is(err, errGraphNotFound):
+		return errorResponse(c, http.StatusNotFound, codeGraphNotFound,
+			err.Error())
+	case errors.Is(err, errGraphsTooLarge):
+		return errorResponse(c, http.StatusRequestEntityTooLarge,
+			codeGraphsTooLarge, err.Error())
+	}
+	var verrs validationErrors
+	if errors.As(err, &verrs) {
+		return validationErrorResponse(c, verrs)
+	}
+	var ute *json.UnmarshalTypeError
+	if errors.As(err, &ute) {
+		return errorResponse(c, http.StatusUnprocessableEntity,
+			codeInvalidGraphs, "dashboard graphs is not an array of objects")
+	}
+	return internalError(c, "failed to modify dashboard graphs", err)
+}
+
+func (s *Server) listGraphs(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	var graphs []byte
+
+	found, err := s.db.Select("graphs").From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanValContext(c.UserContext(), &graphs)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	gs, err := parseRawGraphs(graphs)
+	if err != nil {
+		return s.graphsError(c, err)
+	}
+
+	if gs == nil {
+		gs = []rawGraph{}
+	}
+
+	return c.JSON(GraphsRes{Graphs: gs})
+}
+
+func (s *Server) addGraph(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	var g rawGraph
+
+	err = json.Unmarshal(c.Body(), &g)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal graph object",
+			err)
+	}
+	if g == nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidJSON,
+			"failed to JSON unmarshal graph object")
+	}
+
+	id, err := newGraphID()
+	if err != nil {
+		return internalError(c, "failed to generate graph ID", err)
+	}
+
+	g.setID(id)
+
+	var index int
+
+	err = s.modifyGraphs(c.UserContext(), userID, dashboardID, graphAuditDetails{
+		Op: "add_graph", GraphID: id,
+	}, func(gs []rawGraph) ([]rawGraph, error) {
+		index = len(gs)
+		return append(gs, g), nil
+	})
+	if err != nil {
+		return s.graphsError(c, err)
+	}
+
+	return c.JSON(AddGraphRes{ID: id, Index: index})
+}
+
+func (s *Server) updateGraph(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	graphID := c.Params("gid")
+
+	var g rawGraph
+
+	err = json.Unmarshal(c.Body(), &g)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal graph object",
+			err)
+	}
+	if g == nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidJSON,
+			"failed to JSON unmarshal graph object")
+	}
+
+	g.setID(graphID)
+
+	err = s.modifyGraphs(c.UserContext(), userID, dashboardID, graphAuditDetails{
+		Op: "update_graph", GraphID: graphID,
+	}, func(gs []rawGraph) ([]rawGraph, error) {
+		i := findGraph(gs, graphID)
+		if i < 0 {
+			return nil, errGraphNotFound
+		}
+		gs[i] = g
+		return gs, nil
+	})
+	if err != nil {
+		return s.graphsError(c, err)
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+func (s *Server) deleteGraph(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	graphID := c.Params("gid")
+
+	err = s.modifyGraphs(c.UserContext(), userID, dashboardID, graphAuditDetails{
+		Op: "delete_graph", GraphID: graphID,
+	}, func(gs []rawGraph) ([]rawGraph, error) {
+		i := findGraph(gs, graphID)
+		if i < 0 {
+			return nil, errGraphNotFound
+		}
+		return append(gs[:i], gs[i+1:]...), nil
+	})
+	if err != nil {
+		return s.graphsError(c, err)
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
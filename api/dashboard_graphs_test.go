@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const (
+	cpuGraph = `{"type": "line", "namespace": "SYS.ECS",` +
+		` "metric_name": "cpu_util"}`
+	memGraph = `{"type": "line", "namespace": "SYS.ECS",` +
+		` "metric_name": "mem_util"}`
+)
+
+// assignedIDs assigns IDs to the graphs and returns them in order.
+func assignedIDs(t *testing.T, graphs string) []string {
+	t.Helper()
+
+	res, err := assignGraphIDs(json.RawMessage(graphs))
+	if err != nil {
+		t.Fatalf("assignGraphIDs(%s) error: %v", graphs, err)
+	}
+
+	gs, err := parseRawGraphs(res)
+	if err != nil {
+		t.Fatalf("failed to parse assigned graphs %s: %v", res, err)
+	}
+
+	ids := make([]string, len(gs))
+	for i, g := range gs {
+		ids[i] = g.id()
+		if ids[i] == "" {
+			t.Errorf("graph %d has no ID: %s", i, res)
+		}
+	}
+
+	return ids
+}
+
+func TestAssignGraphIDsDeterministic(t *testing.T) {
+	graphs := "[" + cpuGraph + "," + memGraph + "]"
+
+	first := assignedIDs(t, graphs)
+	second := assignedIDs(t, graphs)
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("graph %d got IDs %s and %s, want the same", i, first[i],
+				second[i])
+		}
+	}
+
+	if first[0] == first[1] {
+		t.Errorf("different graphs got the same ID %s", first[0])
+	}
+}
+
+func TestAssignGraphIDsIdenticalGraphs(t *testing.T) {
+	ids := assignedIDs(t, "["+cpuGraph+","+cpuGraph+"]")
+
+	if ids[0] == ids[1] {
+		t.Errorf("identical graphs at different positions got the same ID"+
+			" %s", ids[0])
+	}
+}
+
+func TestAssignGraphIDsKeepsExisting(t *testing.T) {
+	ids := assignedIDs(t, "["+cpuGraph+","+memGraph+"]")
+
+	// Assigned graphs are reordered and a new one is inserted first, as
+	// update does.
+	reordered := `[` + cpuGraph + `,` +
+		`{"id": "` + ids[1] + `", "type": "line", "namespace": "SYS.ECS",` +
+		` "metric_name": "mem_util"},` +
+		`{"id": "` + ids[0] + `", "type": "line", "namespace": "SYS.ECS",` +
+		` "metric_name": "cpu_util"}]`
+
+	got := assignedIDs(t, reordered)
+
+	if got[1] != ids[1] || got[2] != ids[0] {
+		t.Errorf("got IDs %v, want existing IDs %s and %s kept in place",
+			got, ids[1], ids[0])
+	}
+	if got[0] == ids[0] || got[0] == ids[1] {
+		t.Errorf("new graph got existing ID %s", got[0])
+	}
+}
+
+func TestAssignGraphIDsAllPresent(t *testing.T) {
+	graphs := `[{"id":"a","type":"line","namespace":"SYS.ECS",` +
+		`"metric_name":"cpu_util"}]`
+
+	res, err := assignGraphIDs(json.RawMessage(graphs))
+	if err != nil {
+		t.Fatalf("assignGraphIDs error: %v", err)
+	}
+	if string(res) != graphs {
+		t.Errorf("graphs with IDs are modified: %s", res)
+	}
+}
+
+func TestAssignGraphIDsNull(t *testing.T) {
+	for _, graphs := range []string{"", "null"} {
+		res, err := assignGraphIDs(json.RawMessage(graphs))
+		if err != nil {
+			t.Fatalf("assignGraphIDs(%q) error: %v", graphs, err)
+		}
+		if string(res) != "[]" {
+			t.Errorf("assignGraphIDs(%q) = %s, want []", graphs, res)
+		}
+	}
+}
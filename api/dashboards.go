@@ -0,0 +1,549 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Dashboard struct {
+	ID         int             `db:"id" json:"id"`
+	UserID     string          `db:"user_id" json:"-"`
+	Name       string          `db:"name" json:"name"`
+	Graphs     json.RawMessage `db:"graphs" json:"graphs"`
+	Layout     json.RawMessage `db:"layout" json:"layout,omitempty"`
+	IsFavorite bool            `db:"is_favorite" json:"is_favorite"`
+	SortOrder  int             `db:"sort_order" json:"sort_order"`
+	UpdatedAt  time.Time       `db:"updated_at" json:"updated_at"`
+
+	// Description is optional dashboard notes. It's stored as NULL if empty.
+	Description string `db:"description" json:"description,omitempty"`
+
+	// GraphsVersion is a graphs format version of the payload. It isn't
+	// stored: graphs are always stored in the current format.
+	GraphsVersion int `db:"-" json:"graphs_version,omitempty"`
+}
+
+const (
+	defaultDashboardsLimit = 50
+	maxDashboardsLimit     = 200
+
+	// maxSearchLen is max length of dashboards search query in characters.
+	maxSearchLen = 200
+)
+
+// likeEscaper escapes LIKE pattern special characters with the default
+// escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+type DashboardsRes struct {
+	Dashboards []map[string]interface{} `json:"dashboard"`
+	NextCursor string                   `json:"next_cursor"`
+	PrevCursor string                   `json:"prev_cursor"`
+}
+
+type DashboardRes struct {
+	Dashboard map[string]interface{} `json:"dashboard"`
+}
+
+type AddDashboardsRes struct {
+	ID int `json:"id"`
+}
+
+func (s *Server) listDashboards(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	notModified, err := s.dashboardsNotModified(c, userID)
+	if err != nil {
+		return internalError(c, "failed to get dashboards version from DB",
+			err)
+	}
+	if notModified {
+		return c.SendStatus(http.StatusNotModified)
+	}
+
+	if c.Query("ids") != "" {
+		return s.bulkGetDashboards(c, userID)
+	}
+
+	where := []goqu.Expression{goqu.Ex{"user_id": userID}}
+
+	search, err := dashboardsSearch(c.Query("q"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_search",
+			err.Error())
+	}
+	if search != nil {
+		where = append(where, search)
+	}
+
+	return s.paginateDashboards(c, goqu.And(where...), dashboardFields,
+		listDashboardFields)
+}
+
+// dashboardsSearch returns filter of dashboards with name or description
+// containing the query case-insensitively. It's nil for blank query.
+func dashboardsSearch(q string) (goqu.Expression, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, nil
+	}
+
+	if !utf8.ValidString(q) {
+		return nil, errors.New("search query must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(q) > maxSearchLen {
+		return nil, fmt.Errorf("search query must be at most %d characters"+
+			" long", maxSearchLen)
+	}
+
+	pattern := "%" + likeEscaper.Replace(q) + "%"
+
+	return goqu.Or(goqu.C("name").ILike(pattern),
+		goqu.C("description").ILike(pattern)), nil
+}
+
+// dashboardsNotModified sets the user dashboards list validators and reports
+// whether the client's cached list is still valid. It's a single aggregate
+// query, so the list query is skipped when nothing changed. If-None-Match
+// takes precedence over If-Modified-Since, since only ETag detects deletes.
+func (s *Server) dashboardsNotModified(c *fiber.Ctx, userID string) (
+	bool, error) {
+
+	var v struct {
+		Count        int          `db:"count"`
+		LastModified sql.NullTime `db:"last_modified"`
+	}
+
+	_, err := s.readDB.Select(goqu.COUNT("*").As("count"),
+		goqu.MAX("updated_at").As("last_modified")).From("dashboard").
+		Where(goqu.Ex{"user_id": userID}).
+		Executor().ScanStructContext(c.UserContext(), &v)
+	if err != nil {
+		return false, err
+	}
+
+	etag := dashboardsETag(v.Count, v.LastModified.Time)
+
+	c.Set(fiber.HeaderETag, etag)
+
+	if v.LastModified.Valid {
+		c.Set(fiber.HeaderLastModified,
+			v.LastModified.Time.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		return etagMatches(inm, etag), nil
+	}
+
+	if ims := c.Get(fiber.HeaderIfModifiedSince); ims != "" &&
+		v.LastModified.Valid {
+		return notModifiedSince(ims, v.LastModified.Time), nil
+	}
+
+	return false, nil
+}
+
+// paginateDashboards responds with cursor paginated page of dashboards
+// matching the where clause. Fields are restricted to the known ones and
+// default to the def.
+func (s *Server) paginateDashboards(c *fiber.Ctx, where goqu.Expression,
+	known, def []string) error {
+
+	limit := defaultDashboardsLimit
+
+	if l := c.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxDashboardsLimit {
+			return errorResponse(c, http.StatusBadRequest, "invalid_limit",
+				"limit must be integer from 1 to "+
+					strconv.Itoa(maxDashboardsLimit))
+		}
+	}
+
+	fields, err := parseFields(c.Query("fields"), known, def)
+	if err != nil {
+		return fieldsErrorResponse(c, err)
+	}
+
+	cur := cursor{Direction: cursorNext}
+	hasCursor := c.Query("cursor") != ""
+
+	if hasCursor {
+		cur, err = s.decodeCursor(c.Query("cursor"))
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, "invalid_cursor",
+				err.Error())
+		}
+	}
+
+	q := s.readDB.Select(fieldColumns(fields, "id", "is_favorite",
+		"sort_order", "name")...).
+		From("dashboard").Where(where).
+		Order(dashboardsOrder(cur.Direction)...).Limit(uint(limit + 1))
+
+	if hasCursor {
+		q = q.Where(cur.keyset())
+	}
+
+	ds := []Dashboard{}
+
+	err = q.Executor().ScanStructsContext(c.UserContext(), &ds)
+	if err != nil {
+		return internalError(c, "failed to get dashboards from DB", err)
+	}
+
+	hasMore := len(ds) > limit
+	if hasMore {
+		ds = ds[:limit]
+	}
+
+	if cur.Direction == cursorPrev {
+		for i, j := 0, len(ds)-1; i < j; i, j = i+1, j-1 {
+			ds[i], ds[j] = ds[j], ds[i]
+		}
+	}
+
+	res := DashboardsRes{Dashboards: make([]map[string]interface{}, len(ds))}
+
+	for i, d := range ds {
+		res.Dashboards[i] = dashboardFieldValues(d, fields)
+	}
+
+	if len(ds) > 0 {
+		first, last := ds[0], ds[len(ds)-1]
+
+		if cur.Direction == cursorNext && hasMore ||
+			cur.Direction == cursorPrev {
+			res.NextCursor = s.encodeCursor(dashboardCursor(last,
+				cursorNext))
+		}
+
+		if cur.Direction == cursorPrev && hasMore ||
+			cur.Direction == cursorNext && hasCursor {
+			res.PrevCursor = s.encodeCursor(dashboardCursor(first,
+				cursorPrev))
+		}
+	}
+
+	return c.JSON(res)
+}
+
+func (s *Server) getDashboard(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	fields, err := parseFields(c.Query("fields"), dashboardFields,
+		dashboardFields)
+	if err != nil {
+		return fieldsErrorResponse(c, err)
+	}
+
+	var d Dashboard
+
+	found, err := s.readDB.Select(fieldColumns(fields, "id", "updated_at")...).
+		From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanStructContext(c.UserContext(), &d)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	// HEAD is an existence check, not a view.
+	if c.Method() != fiber.MethodHead {
+		s.recordView(userID, d.ID)
+	}
+
+	etag := dashboardETag(d.ID, d.UpdatedAt)
+
+	c.Set(fiber.HeaderETag, etag)
+
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" &&
+		etagMatches(inm, etag) {
+		return c.SendStatus(http.StatusNotModified)
+	}
+
+	return c.JSON(DashboardRes{Dashboard: dashboardFieldValues(d, fields)})
+}
+
+func (s *Server) deleteDashboard(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	ctx := c.UserContext()
+
+	var deleted bool
+
+	err = withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+		var name string
+
+		deleted, err = tx.From("dashboard").Delete().Where(
+			goqu.Ex{"id": dashboardID, "user_id": userID}).
+			Returning("name").Executor().ScanValContext(ctx, &name)
+		if err != nil {
+			return err
+		}
+
+		if deleted {
+			audit(ctx, tx, userID, auditDelete, dashboardID,
+				map[string]string{"name": name})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return internalError(c, "failed to delete dashboard from db", err)
+	}
+
+	if deleted {
+		s.dashboardChanged(eventDeleted, dashboardID)
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+func (s *Server) createDashboard(c *fiber.Ctx) error {
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	ctx := c.UserContext()
+
+	var d Dashboard
+
+	err := json.Unmarshal(c.Body(), &d)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal dashboard", err)
+	}
+
+	d.Name, err = validateName(d.Name)
+	if err != nil {
+		return nameErrorResponse(c, err)
+	}
+
+	// Dashboard without graphs is seeded from the default template on
+	// demand.
+	switch c.Query("template") {
+	case "":
+	case "default":
+		if isEmptyGraphs(d.Graphs) && s.config.DefaultTemplateID > 0 {
+			d.Graphs, err = s.defaultGraphs(ctx)
+			if err != nil {
+				if errors.Is(err, errDefaultTemplateNotFound) {
+					return errorResponse(c, http.StatusNotFound,
+						"template_not_found", err.Error())
+				}
+				return internalError(c, "failed to get default template"+
+					" from DB", err)
+			}
+		}
+	default:
+		return errorResponse(c, http.StatusBadRequest, "invalid_template",
+			"template must be default")
+	}
+
+	err = s.checkGraphsSize(d.Graphs)
+	if err != nil {
+		return errorResponse(c, http.StatusRequestEntityTooLarge,
+			codeGraphsTooLarge, err.Error())
+	}
+
+	d.ID = 0
+
+	if errs := validateLayout(d.Layout, d.Graphs); len(errs) > 0 {
+		return layoutErrorResponse(c, errs)
+	}
+
+	// Dashboard is validated within idempotency key lock, otherwise retry
+	// would fail on name uniqueness instead of replaying.
+	if key := c.Get(HeaderIdempotencyKey); key != "" &&
+		c.Query("validate") != "true" {
+		return s.createDashboardIdempotent(c, userID, key, d)
+	}
+
+	errs, err := s.validateDashboard(ctx, userID, d)
+	if err != nil {
+		return internalError(c, "failed to validate dashboard", err)
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	if c.Query("validate") == "true" {
+		return c.JSON(ValidRes{Valid: true})
+	}
+
+	id, err := s.insertDashboard(ctx, userID, d)
+	if err != nil {
+		return internalError(c, "failed to insert dashboard to db", err)
+	}
+
+	return c.JSON(AddDashboardsRes{ID: id})
+}
+
+// insertDashboard inserts the user dashboard and records it to the audit log.
+func (s *Server) insertDashboard(ctx context.Context, userID string,
+	d Dashboard) (int, error) {
+
+	var id int
+
+	err := withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+		var err error
+		id, err = insertDashboardTx(ctx, tx, userID, d)
+		return err
+	})
+
+	return id, err
+}
+
+func insertDashboardTx(ctx context.Context, tx *goqu.TxDatabase,
+	userID string, d Dashboard) (int, error) {
+
+	graphs, err := assignGraphIDs(d.Graphs)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+
+	_, err = tx.Insert("dashboard").
+		Cols("user_id", "name", "description", "graphs", "layout").
+		Vals(goqu.Vals{userID, d.Name, textOrNull(d.Description),
+			goqu.L("?::jsonb", string(graphs)), jsonbOrNull(d.Layout)}).
+		Returning("id").Executor().ScanValContext(ctx, &id)
+	if err != nil {
+		return 0, err
+	}
+
+	audit(ctx, tx, userID, auditCreate, id,
+		map[string]string{"name": d.Name})
+
+	return id, nil
+}
+
+// textOrNull returns NULL for empty text.
+func textOrNull(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (s *Server) updateDashboard(c *fiber.Ctx) error {
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	ctx := c.UserContext()
+
+	var d Dashboard
+
+	err := json.Unmarshal(c.Body(), &d)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal dashboard", err)
+	}
+
+	d.Name, err = validateName(d.Name)
+	if err != nil {
+		return nameErrorResponse(c, err)
+	}
+
+	err = s.checkGraphsSize(d.Graphs)
+	if err != nil {
+		return errorResponse(c, http.StatusRequestEntityTooLarge,
+			codeGraphsTooLarge, err.Error())
+	}
+
+	if errs := validateLayout(d.Layout, d.Graphs); len(errs) > 0 {
+		return layoutErrorResponse(c, errs)
+	}
+
+	errs, err := s.validateDashboard(ctx, userID, d)
+	if err != nil {
+		return internalError(c, "failed to validate dashboard", err)
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	// Graphs keep their IDs, new graphs get ones.
+	d.Graphs, err = assignGraphIDs(d.Graphs)
+	if err != nil {
+		return internalError(c, "failed to assign graph IDs", err)
+	}
+
+	var updated int64
+
+	err = withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+		res, err := tx.Update("dashboard").Set(goqu.Record{
+			"name":        d.Name,
+			"description": textOrNull(d.Description),
+			"graphs":      goqu.L("?::jsonb", string(d.Graphs)),
+			"layout":      jsonbOrNull(d.Layout),
+			"updated_at":  goqu.L("now()"),
+		}).Where(goqu.Ex{"id": d.ID, "user_id": userID}).
+			Executor().ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		updated, err = res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if updated > 0 {
+			audit(ctx, tx, userID, auditUpdate, d.ID,
+				map[string]string{"name": d.Name})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return internalError(c, "failed to update dashboard in db", err)
+	}
+
+	if updated > 0 {
+		s.dashboardChanged(eventUpdated, d.ID)
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+)
+
+func TestDashboardsSearch(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"cpu", `(("name" ILIKE '%cpu%') OR ("description" ILIKE '%cpu%'))`},
+		{" RDS ", `(("name" ILIKE '%RDS%') OR ("description" ILIKE '%RDS%'))`},
+		// LIKE special characters match literally.
+		{`50%_\`, `(("name" ILIKE '%50\%\_\\%') OR` +
+			` ("description" ILIKE '%50\%\_\\%'))`},
+	} {
+		search, err := dashboardsSearch(tc.q)
+		if err != nil {
+			t.Errorf("search %q error: %v", tc.q, err)
+			continue
+		}
+
+		if tc.want == "" {
+			if search != nil {
+				t.Errorf("got search %v of %q, want nil", search, tc.q)
+			}
+			continue
+		}
+
+		sql, _, err := goqu.From("dashboard").Where(search).ToSQL()
+		if err != nil {
+			t.Fatalf("failed to build SQL: %v", err)
+		}
+		if !strings.HasSuffix(sql, "WHERE "+tc.want) {
+			t.Errorf("got SQL %s of %q, want filter %s", sql, tc.q, tc.want)
+		}
+	}
+}
+
+func TestDashboardsSearchInvalid(t *testing.T) {
+	for _, q := range []string{
+		strings.Repeat("a", maxSearchLen+1),
+		"\xff",
+	} {
+		if _, err := dashboardsSearch(q); err == nil {
+			t.Errorf("got no error of search %q", q)
+		}
+	}
+
+	if _, err := dashboardsSearch(strings.Repeat("я", maxSearchLen)); err !=
+		nil {
+		t.Errorf("got error of max length search: %v", err)
+	}
+}
+
+func TestListDashboardsSearch(t *testing.T) {
+	fdb, app := newFakeDBTestApp(t,
+		func(query string) ([]string, [][]driver.Value) {
+			return []string{"id"}, nil
+		})
+
+	res, err := app.Test(newTestRequest(http.MethodGet,
+		"/dashboards?q=cpu"), -1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	lists := fdb.find(`"name" ILIKE '%cpu%'`)
+	if len(lists) != 1 || !strings.Contains(lists[0].query,
+		`"description" ILIKE '%cpu%'`) || !strings.Contains(lists[0].query,
+		`"user_id" = '`+testUserID+`'`) {
+		t.Errorf("got queries %v, want user list searched by name and"+
+			" description", fdb.find("SELECT"))
+	}
+
+	res, err = app.Test(newTestRequest(http.MethodGet,
+		"/dashboards?q="+strings.Repeat("a", maxSearchLen+1)), -1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+
+	er := decodeErrorRes(t, res)
+	if res.StatusCode != http.StatusBadRequest ||
+		er.Error.Code != "invalid_search" {
+		t.Errorf("got %d %+v of too long search, want 400 invalid_search",
+			res.StatusCode, er.Error)
+	}
+}
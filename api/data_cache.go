@@ -0,0 +1,124 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+type dataCacheItem struct {
+	res       DashboardDataRes
+	expiresAt time.Time
+}
+
+// dataCache is an in-memory cache of dashboards data bounded by number of
+// items. Items are grouped by dashboard, so dashboard writes invalidate all
+// its data.
+type dataCache struct {
+	ttl      time.Duration
+	maxItems int
+
+	mx    sync.Mutex
+	items map[int]map[string]dataCacheItem
+	size  int
+}
+
+// newDataCache creates new dataCache. Zero maxItems means no limit.
+func newDataCache(ttl time.Duration, maxItems int) *dataCache {
+	return &dataCache{
+		ttl:      ttl,
+		maxItems: maxItems,
+		items:    map[int]map[string]dataCacheItem{},
+	}
+}
+
+func (c *dataCache) get(dashboardID int, key string) (DashboardDataRes,
+	bool) {
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	i, ok := c.items[dashboardID][key]
+	if !ok {
+		return DashboardDataRes{}, false
+	}
+
+	if time.Now().After(i.expiresAt) {
+		c.remove(dashboardID, key)
+		return DashboardDataRes{}, false
+	}
+
+	return i.res, true
+}
+
+// set caches the dashboard data. If the cache is full expired items are
+// removed and then the item expiring first.
+func (c *dataCache) set(dashboardID int, key string, res DashboardDataRes) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := time.Now()
+
+	if _, ok := c.items[dashboardID][key]; !ok && c.maxItems > 0 &&
+		c.size >= c.maxItems {
+		c.evict(now)
+	}
+
+	is, ok := c.items[dashboardID]
+	if !ok {
+		is = map[string]dataCacheItem{}
+		c.items[dashboardID] = is
+	}
+
+	if _, ok := is[key]; !ok {
+		c.size++
+	}
+
+	is[key] = dataCacheItem{res: res, expiresAt: now.Add(c.ttl)}
+}
+
+// invalidate removes all data of the dashboard.
+func (c *dataCache) invalidate(dashboardID int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.size -= len(c.items[dashboardID])
+	delete(c.items, dashboardID)
+}
+
+func (c *dataCache) remove(dashboardID int, key string) {
+	is := c.items[dashboardID]
+	if _, ok := is[key]; !ok {
+		return
+	}
+
+	delete(is, key)
+	c.size--
+
+	if len(is) == 0 {
+		delete(c.items, dashboardID)
+	}
+}
+
+func (c *dataCache) evict(now time.Time) {
+	var (
+		firstID  int
+		firstKey string
+		first    time.Time
+	)
+
+	for id, is := range c.items {
+		for k, i := range is {
+			if now.After(i.expiresAt) {
+				c.remove(id, k)
+				continue
+			}
+			if first.IsZero() || i.expiresAt.Before(first) {
+				firstID, firstKey, first = id, k, i.expiresAt
+			}
+		}
+	}
+
+	if c.size >= c.maxItems && !first.IsZero() {
+		c.remove(firstID, firstKey)
+	}
+}
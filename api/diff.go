@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GraphChange is a graph modified between snapshots.
+type GraphChange struct {
+	ID   string   `json:"id"`
+	From rawGraph `json:"from"`
+	To   rawGraph `json:"to"`
+}
+
+type DiffRes struct {
+	From     int           `json:"from"`
+	To       int           `json:"to"`
+	Added    []rawGraph    `json:"added"`
+	Removed  []rawGraph    `json:"removed"`
+	Modified []GraphChange `json:"modified"`
+}
+
+// graphKey returns stable key of the graph: its ID or position if it has no
+// ID.
+func graphKey(g rawGraph, i int) string {
+	if id := g.id(); id != "" {
+		return id
+	}
+	return "#" + strconv.Itoa(i)
+}
+
+// graphsEqual reports whether graphs are equal ignoring JSON formatting and
+// keys order.
+func graphsEqual(a, b rawGraph) bool {
+	var av, bv interface{}
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	if json.Unmarshal(aj, &av) != nil || json.Unmarshal(bj, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// diffGraphs returns graphs added, removed and modified from the from graphs
+// to the to graphs matched by graph keys.
+func diffGraphs(from, to []rawGraph) (added, removed []rawGraph,
+	modified []GraphChange) {
+
+	added, removed, modified = []rawGraph{}, []rawGraph{}, []GraphChange{}
+
+	fromByKey := map[string]rawGraph{}
+	for i, g := range from {
+		fromByKey[graphKey(g, i)] = g
+	}
+
+	toKeys := map[string]bool{}
+
+	for i, g := range to {
+		k := graphKey(g, i)
+		toKeys[k] = true
+
+		fg, ok := fromByKey[k]
+		switch {
+		case !ok:
+			added = append(added, g)
+		case !graphsEqual(fg, g):
+			modified = append(modified, GraphChange{ID: k, From: fg, To: g})
+		}
+	}
+
+	for i, g := range from {
+		if !toKeys[graphKey(g, i)] {
+			removed = append(removed, g)
+		}
+	}
+
+	return added, removed, modified
+}
+
+// diffSnapshots responds with graphs diff between two snapshots of the user
+// dashboard.
+func (s *Server) diffSnapshots(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	var errs []ValidationError
+
+	fromID, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		errs = append(errs, ValidationError{Field: "from",
+			Message: "must be snapshot ID"})
+	}
+
+	toID, err := strconv.Atoi(c.Query("to"))
+	if err != nil {
+		errs = append(errs, ValidationError{Field: "to",
+			Message: "must be snapshot ID"})
+	}
+
+	if len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_snapshot_id", "snapshot IDs are invalid", errs)
+	}
+
+	sns := []Snapshot{}
+
+	err = s.readDB.Select("id", "graphs").From("dashboard_snapshot").
+		Where(goqu.Ex{
+			"id":           []int{fromID, toID},
+			"dashboard_id": dashboardID,
+			"user_id":      userID,
+		}).Executor().ScanStructsContext(c.UserContext(), &sns)
+	if err != nil {
+		return internalError(c, "failed to get snapshots from DB", err)
+	}
+
+	graphs := map[int][]rawGraph{}
+
+	for _, sn := range sns {
+		gs, err := parseRawGraphs(sn.Graphs)
+		if err != nil {
+			return internalError(c, "failed to parse snapshot graphs", err)
+		}
+		graphs[sn.ID] = gs
+	}
+
+	for _, p := range []struct {
+		field string
+		id    int
+	}{{"from", fromID}, {"to", toID}} {
+		if _, ok := graphs[p.id]; !ok {
+			return errorDetailsResponse(c, http.StatusNotFound,
+				"snapshot_not_found", "snapshot not found in the dashboard",
+				[]ValidationError{{Field: p.field,
+					Message: "snapshot " + strconv.Itoa(p.id) +
+						" doesn't belong to the dashboard"}})
+		}
+	}
+
+	res := DiffRes{From: fromID, To: toID}
+
+	res.Added, res.Removed, res.Modified = diffGraphs(graphs[fromID],
+		graphs[toID])
+
+	return c.JSON(res)
+}
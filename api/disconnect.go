@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// disconnectPollInterval is an interval of checks whether the client is
+// still connected.
+const disconnectPollInterval = 100 * time.Millisecond
+
+// cancelOnDisconnect cancels the handlers user context when the client
+// closes the connection. fasthttp doesn't watch connections while handlers
+// run, so the connection is polled until the handler returns. Responses
+// streamed after that are cut by fasthttp itself on failed write.
+func cancelOnDisconnect(c *fiber.Ctx) error {
+	conn := c.Context().Conn()
+
+	ctx, cancel := context.WithCancel(c.UserContext())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(disconnectPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if connClosed(conn) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	c.SetUserContext(ctx)
+
+	return c.Next()
+}
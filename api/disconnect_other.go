@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package api
+
+import "net"
+
+// connClosed can't check connections on this platform, so client disconnect
+// is only noticed on failed write.
+func connClosed(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+// closeTrackingTransport records close of upstream response bodies.
+type closeTrackingTransport struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newCloseTrackingTransport() *closeTrackingTransport {
+	return &closeTrackingTransport{closed: make(chan struct{})}
+}
+
+func (t *closeTrackingTransport) RoundTrip(r *http.Request) (*http.Response,
+	error) {
+
+	res, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = closeTrackingBody{ReadCloser: res.Body, onClose: func() {
+		t.once.Do(func() { close(t.closed) })
+	}}
+
+	return res, nil
+}
+
+type closeTrackingBody struct {
+	io.ReadCloser
+	onClose func()
+}
+
+func (b closeTrackingBody) Close() error {
+	b.onClose()
+	return b.ReadCloser.Close()
+}
+
+// serveTestApp serves the app on a local TCP port until the test ends.
+func serveTestApp(t *testing.T, app *fiber.App) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	go app.Listener(ln)
+
+	t.Cleanup(func() {
+		app.Shutdown()
+	})
+
+	return ln.Addr().String()
+}
+
+// dialCESRequest connects to the app and sends CES proxy request.
+func dialCESRequest(t *testing.T, addr, path string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	_, err = fmt.Fprintf(conn, "GET /ces/%s HTTP/1.1\r\nHost: test\r\n"+
+		"%s: token\r\n\r\n", path, core.HeaderXAuthToken)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	return conn
+}
+
+// waitClosed waits for the channel close or fails the test.
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s isn't closed after client disconnect", what)
+	}
+}
+
+func TestCESClientDisconnectWhileWaiting(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("connection checks aren't supported on", runtime.GOOS)
+	}
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Part of the body is sent, and the rest never comes.
+			w.Write([]byte(`{"metrics":[`))
+			w.(http.Flusher).Flush()
+			close(started)
+			<-r.Context().Done()
+			close(cancelled)
+		}))
+	defer ces.Close()
+
+	transport := newCloseTrackingTransport()
+
+	_, app := newTestAppClient(t, ces.URL, &http.Client{Transport: transport},
+		Config{CESSharedBodyLimit: 1024 * 1024})
+
+	conn := dialCESRequest(t, serveTestApp(t, app), "V1.0/metrics")
+
+	<-started
+	conn.Close()
+
+	waitClosed(t, transport.closed, "upstream response body")
+	waitClosed(t, cancelled, "upstream request")
+}
+
+func TestCESClientDisconnectMidStream(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer close(cancelled)
+
+			chunk := []byte(strings.Repeat("x", 1024))
+
+			// Body is streamed until the request is cancelled.
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				default:
+				}
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				time.Sleep(time.Millisecond)
+			}
+		}))
+	defer ces.Close()
+
+	transport := newCloseTrackingTransport()
+
+	// Sharing is disabled, so the response is streamed.
+	_, app := newTestAppClient(t, ces.URL, &http.Client{Transport: transport},
+		Config{})
+
+	conn := dialCESRequest(t, serveTestApp(t, app), "V1.0/metric-data")
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	_, err = io.ReadFull(res.Body, make([]byte, 4096))
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+
+	conn.Close()
+
+	waitClosed(t, transport.closed, "upstream response body")
+	waitClosed(t, cancelled, "upstream request")
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package api
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// connClosed reports whether the peer closed the connection. Pending data
+// is peeked and left for the server to read, so pipelined requests aren't
+// consumed. Connections without file descriptor, like TLS ones, are never
+// reported closed.
+func connClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+
+	err = rc.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), b[:],
+			syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = n == 0 && err == nil ||
+			errors.Is(err, syscall.ECONNRESET)
+		// Done regardless of readiness, so the check never blocks.
+		return true
+	})
+
+	return err == nil && closed
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const (
+	codeInternal           = "internal"
+	codeInvalidJSON        = "invalid_json"
+	codeInvalidDashboardID = "invalid_dashboard_id"
+	codeDashboardNotFound  = "dashboard_not_found"
+	codeGraphNotFound      = "graph_not_found"
+	codeInvalidGraphs      = "invalid_graphs"
+	codeGraphsTooLarge     = "graphs_too_large"
+	codeCESCircuitOpen     = "ces_circuit_open"
+	codeCESUnavailable     = "ces_unavailable"
+	codeIAMUnavailable     = "iam_unavailable"
+	codeClientClosed       = "client_closed_request"
+)
+
+// statusClientClosedRequest is a non-standard status of requests cancelled by
+// the client before the response is ready. The client is gone, so it's only
+// seen in access logs.
+const statusClientClosedRequest = 499
+
+type Error struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type ErrorRes struct {
+	Error Error `json:"error"`
+}
+
+func errorResponse(c *fiber.Ctx, status int, code, msg string) error {
+	return errorDetailsResponse(c, status, code, msg, nil)
+}
+
+func errorDetailsResponse(c *fiber.Ctx, status int, code, msg string,
+	details interface{}) error {
+	return c.Status(status).JSON(ErrorRes{Error: Error{
+		Code:    code,
+		Message: msg,
+		Details: details,
+	}})
+}
+
+// internalError logs the err and responds with the msg not leaking the err
+// details to the client. Errors of requests cancelled by the client aren't
+// server failures, so they are logged with debug level and responded with
+// 499.
+func internalError(c *fiber.Ctx, msg string, err error) error {
+	// Driver may report cancellation with its own error, so the request
+	// context is checked too.
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(c.UserContext().Err(), context.Canceled) {
+		logger.Debugf("[cancelled] method=%s path=%s: %s: %v", c.Method(),
+			c.Path(), msg, err)
+		return errorResponse(c, statusClientClosedRequest, codeClientClosed,
+			"request cancelled by client")
+	}
+
+	logger.Errorf("[error] method=%s path=%s: %s: %v", c.Method(), c.Path(),
+		msg, err)
+	return errorResponse(c, http.StatusInternalServerError, codeInternal, msg)
+}
+
+// statusCode returns error code of the HTTP status, e.g. not_found.
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)),
+		" ", "_")
+}
+
+// requestID returns ID of the request from X-Request-ID header or its trace
+// ID if tracing is enabled.
+func requestID(c *fiber.Ctx) string {
+	if id := c.Get(fiber.HeaderXRequestID); id != "" {
+		return id
+	}
+	if sc := trace.SpanContextFromContext(c.UserContext()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// StackTraceHandler logs panic recovered from a handler with its stack. The
+// client gets plain 500 from ErrorHandler without the stack.
+func StackTraceHandler(c *fiber.Ctx, e interface{}) {
+	logger.Errorf("[panic] method=%s path=%s request_id=%s: %v\n%s",
+		c.Method(), c.Path(), requestID(c), e, debug.Stack())
+}
+
+type RouteNotFoundDetails struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// routeNotFound is a fallback handler registered after all routes. Router
+// decides whether the path is unknown or the method isn't allowed, so 405
+// with Allow header is kept, and 404 is responded as ErrorRes.
+func routeNotFound(c *fiber.Ctx) error {
+	err := c.Next()
+
+	var fe *fiber.Error
+	if errors.As(err, &fe) && fe.Code == http.StatusNotFound {
+		return errorDetailsResponse(c, http.StatusNotFound,
+			statusCode(http.StatusNotFound), "route not found",
+			RouteNotFoundDetails{Method: c.Method(), Path: c.Path()})
+	}
+
+	return err
+}
+
+// ErrorHandler formats errors returned by handlers or recovered from panics
+// as ErrorRes.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		if fe.Code >= http.StatusInternalServerError {
+			return internalError(c, http.StatusText(fe.Code), err)
+		}
+		return errorResponse(c, fe.Code, statusCode(fe.Code), fe.Message)
+	}
+	return internalError(c, "internal server error", err)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// decodeErrorRes decodes ErrorRes of the response.
+func decodeErrorRes(t *testing.T, res *http.Response) ErrorRes {
+	t.Helper()
+
+	defer res.Body.Close()
+
+	if ct := res.Header.Get(fiber.HeaderContentType); !strings.HasPrefix(ct,
+		fiber.MIMEApplicationJSON) {
+		t.Fatalf("got Content-Type %q, want JSON", ct)
+	}
+
+	var er ErrorRes
+
+	err := json.NewDecoder(res.Body).Decode(&er)
+	if err != nil {
+		t.Fatalf("failed to JSON decode error response: %v", err)
+	}
+
+	return er
+}
+
+func TestPanicRecovered(t *testing.T) {
+	logs := captureLogs(t, logger.Info)
+
+	app := newFiberApp()
+
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("boom")
+	})
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	r, _ := http.NewRequest(http.MethodGet, "/panic", nil)
+	r.Header.Set(fiber.HeaderXRequestID, "req-1")
+
+	res, err := app.Test(r, -1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", res.StatusCode,
+			http.StatusInternalServerError)
+	}
+
+	er := decodeErrorRes(t, res)
+	if er.Error.Code != codeInternal {
+		t.Errorf("got error code %q, want %q", er.Error.Code, codeInternal)
+	}
+	if strings.Contains(er.Error.Message, "boom") ||
+		strings.Contains(er.Error.Message, "goroutine") {
+		t.Errorf("panic details leaked to client: %q", er.Error.Message)
+	}
+
+	out := logs.String()
+	for _, want := range []string{"[panic]", "request_id=req-1", "boom",
+		"goroutine"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log doesn't contain %q: %s", want, out)
+		}
+	}
+
+	// Server keeps serving after the panic.
+	res, err = app.Test(httpGet("/ok"), -1)
+	if err != nil {
+		t.Fatalf("failed to do request after panic: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d after panic, want %d", res.StatusCode,
+			http.StatusOK)
+	}
+}
+
+func TestRouteNotFound(t *testing.T) {
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		}))
+	defer ces.Close()
+
+	_, app := newTestApp(t, ces.URL, Config{})
+
+	res, err := app.Test(newTestRequest(http.MethodGet, "/no/such/route"), -1)
+	if err != nil {
+		t.Fatalf("failed to do request: %v", err)
+	}
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", res.StatusCode,
+			http.StatusNotFound)
+	}
+
+	er := decodeErrorRes(t, res)
+	if er.Error.Code != "not_found" || er.Error.Message != "route not found" {
+		t.Errorf("got error %+v, want not_found route not found", er.Error)
+	}
+
+	details, _ := er.Error.Details.(map[string]interface{})
+	if details["method"] != http.MethodGet ||
+		details["path"] != "/no/such/route" {
+		t.Errorf("got details %v, want method and path", er.Error.Details)
+	}
+
+	for _, tc := range []struct {
+		method string
+		target string
+		status int
+	}{
+		// CES wildcard isn't shadowed.
+		{http.MethodGet, "/ces/V1.0/metrics", http.StatusOK},
+		// Dashboard routes are handled before DB access.
+		{http.MethodGet, "/dashboards/abc", http.StatusBadRequest},
+		// Unsupported method of known path is 405, not 404.
+		{http.MethodPatch, "/dashboards", http.StatusMethodNotAllowed},
+	} {
+		res, err := app.Test(newTestRequest(tc.method, tc.target), -1)
+		if err != nil {
+			t.Fatalf("failed to do request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tc.status {
+			t.Errorf("%s %s got status %d, want %d", tc.method, tc.target,
+				res.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestRouteNotFoundWithoutToken(t *testing.T) {
+	_, app := newTestApp(t, "http://127.0.0.1:1", Config{})
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		// Unknown routes aren't behind auth.
+		{"/no/such/route", http.StatusNotFound},
+		{"/dashboard", http.StatusNotFound},
+		// Known routes still require token.
+		{"/dashboards", http.StatusForbidden},
+		{"/dashboards/1", http.StatusForbidden},
+		{"/snapshots/1", http.StatusForbidden},
+		{"/ces/V1.0/metrics", http.StatusForbidden},
+	} {
+		res, err := app.Test(httpGet(tc.target), -1)
+		if err != nil {
+			t.Fatalf("failed to do request: %v", err)
+		}
+
+		if res.StatusCode != tc.status {
+			t.Errorf("GET %s got status %d, want %d", tc.target,
+				res.StatusCode, tc.status)
+		}
+
+		code := "not_found"
+		if tc.status == http.StatusForbidden {
+			code = "token_absent"
+		}
+
+		er := decodeErrorRes(t, res)
+		if er.Error.Code != code {
+			t.Errorf("GET %s got error %+v, want %s", tc.target, er.Error,
+				code)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dashboardETag returns weak ETag of the dashboard version.
+func dashboardETag(id int, updatedAt time.Time) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%d:%d", id,
+		updatedAt.UnixNano())))
+	return `W/"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// dashboardsETag returns weak ETag of the user dashboards list version. The
+// count is a part of it, because deletes don't change max updated_at.
+func dashboardsETag(count int, lastModified time.Time) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("list:%d:%d", count,
+		lastModified.UnixNano())))
+	return `W/"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// etagMatches reports whether If-None-Match header value matches the ETag
+// using weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether the resource last modified at the time
+// isn't modified since If-Modified-Since header value. Header is compared
+// with the second precision of HTTP dates.
+func notModifiedSince(ifModifiedSince string, lastModified time.Time) bool {
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+
+	// eventsBuffer is a number of events buffered per subscriber. Events
+	// are dropped for subscribers not keeping up.
+	eventsBuffer = 8
+
+	// eventsKeepAlive is an interval of SSE comments keeping connection
+	// alive through proxies and detecting client disconnects.
+	eventsKeepAlive = 15 * time.Second
+)
+
+var errTooManySubscribers = errors.New("too many dashboard subscribers")
+
+// DashboardEvent is a dashboard change notification.
+type DashboardEvent struct {
+	Type        string    `json:"type"`
+	DashboardID int       `json:"dashboard_id"`
+	At          time.Time `json:"at"`
+}
+
+// eventBus is an in-process pub/sub of dashboard events.
+type eventBus struct {
+	maxSubs int
+
+	mx   sync.Mutex
+	subs map[int]map[chan DashboardEvent]struct{}
+}
+
+// newEventBus creates new eventBus with at most maxSubs subscribers per
+// dashboard. Zero maxSubs means no limit.
+func newEventBus(maxSubs int) *eventBus {
+	return &eventBus{
+		maxSubs: maxSubs,
+		subs:    map[int]map[chan DashboardEvent]struct{}{},
+	}
+}
+
+func (b *eventBus) subscribe(dashboardID int) (chan DashboardEvent, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	subs := b.subs[dashboardID]
+	if b.maxSubs > 0 && len(subs) >= b.maxSubs {
+		return nil, errTooManySubscribers
+	}
+
+	if subs == nil {
+		subs = map[chan DashboardEvent]struct{}{}
+		b.subs[dashboardID] = subs
+	}
+
+	ch := make(chan DashboardEvent, eventsBuffer)
+	subs[ch] = struct{}{}
+
+	return ch, nil
+}
+
+func (b *eventBus) unsubscribe(dashboardID int, ch chan DashboardEvent) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	subs := b.subs[dashboardID]
+	delete(subs, ch)
+	if len(subs) == 0 {
+		delete(b.subs, dashboardID)
+	}
+}
+
+// publish sends the event to the dashboard subscribers without blocking.
+func (b *eventBus) publish(eventType string, dashboardID int) {
+	e := DashboardEvent{
+		Type:        eventType,
+		DashboardID: dashboardID,
+		At:          time.Now(),
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for ch := range b.subs[dashboardID] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// dashboardChanged publishes the dashboard change event and invalidates its
+// cached data. Dashboard write handlers must call it after commit.
+func (s *Server) dashboardChanged(eventType string, dashboardID int) {
+	if s.dataCache != nil {
+		s.dataCache.invalidate(dashboardID)
+	}
+	s.events.publish(eventType, dashboardID)
+}
+
+// writeEvent writes the event in SSE format and flushes it to the client.
+func writeEvent(w *bufio.Writer, e DashboardEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// dashboardEvents streams the user dashboard change events as SSE until the
+// client disconnects or the dashboard is deleted.
+func (s *Server) dashboardEvents(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	var id int
+
+	found, err := s.db.Select("id").From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanValContext(c.UserContext(), &id)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	ch, err := s.events.subscribe(dashboardID)
+	if err != nil {
+		return errorResponse(c, http.StatusTooManyRequests,
+			"too_many_subscribers", err.Error())
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer s.events.unsubscribe(dashboardID, ch)
+
+		ticker := time.NewTicker(eventsKeepAlive)
+		defer ticker.Stop()
+
+		// Headers are flushed right away, so the client knows the
+		// subscription is established. Write error means the client is
+		// gone.
+		_, err := w.WriteString(": subscribed\n\n")
+		if err != nil || w.Flush() != nil {
+			return
+		}
+
+		for {
+			select {
+			case e := <-ch:
+				if writeEvent(w, e) != nil || e.Type == eventDeleted {
+					return
+				}
+			case <-ticker.C:
+				_, err := w.WriteString(": ping\n\n")
+				if err != nil || w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const codeInvalidFields = "invalid_fields"
+
+var (
+	// dashboardFields are all dashboard fields in the default order.
+	dashboardFields = []string{"id", "name", "description", "graphs",
+		"layout", "is_favorite", "sort_order", "updated_at"}
+
+	// listDashboardFields are dashboard list fields by default. Graphs are
+	// omitted since they can be large and list view doesn't need them.
+	listDashboardFields = []string{"id", "name", "description", "layout",
+		"is_favorite", "sort_order", "updated_at"}
+
+	// adminDashboardFields are all dashboard fields available to admin.
+	adminDashboardFields = append([]string{"user_id"}, dashboardFields...)
+
+	// adminListDashboardFields are admin dashboard list fields by default.
+	adminListDashboardFields = append([]string{"user_id"},
+		listDashboardFields...)
+)
+
+// parseFields parses comma separated list of the known fields. Empty list
+// results to default fields.
+func parseFields(fields string, known, def []string) ([]string, error) {
+	if fields == "" {
+		return def, nil
+	}
+
+	isKnown := map[string]bool{}
+	for _, f := range known {
+		isKnown[f] = true
+	}
+
+	var fs []string
+	seen := map[string]bool{}
+
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !isKnown[f] {
+			return nil, errors.New("unknown field " + f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			fs = append(fs, f)
+		}
+	}
+
+	if len(fs) == 0 {
+		return nil, errors.New("fields list is empty")
+	}
+
+	return fs, nil
+}
+
+// fieldColumns returns select columns of fields with required ones added.
+func fieldColumns(fields []string, required ...string) []interface{} {
+	var cols []interface{}
+	seen := map[string]bool{}
+
+	for _, f := range append(required, fields...) {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		if f == "description" {
+			// Nullable description is scanned to string.
+			cols = append(cols, goqu.COALESCE(goqu.C(f), "").As(f))
+		} else {
+			cols = append(cols, f)
+		}
+	}
+
+	return cols
+}
+
+// dashboardFieldValues returns dashboard restricted to the fields.
+func dashboardFieldValues(d Dashboard, fields []string) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+
+	for _, f := range fields {
+		switch f {
+		case "id":
+			m[f] = d.ID
+		case "user_id":
+			m[f] = d.UserID
+		case "name":
+			m[f] = d.Name
+		case "description":
+			if d.Description != "" {
+				m[f] = d.Description
+			}
+		case "graphs":
+			m[f] = d.Graphs
+		case "layout":
+			if len(d.Layout) > 0 {
+				m[f] = d.Layout
+			}
+		case "is_favorite":
+			m[f] = d.IsFavorite
+		case "sort_order":
+			m[f] = d.SortOrder
+		case "updated_at":
+			m[f] = d.UpdatedAt
+		}
+	}
+
+	return m
+}
+
+func fieldsErrorResponse(c *fiber.Ctx, err error) error {
+	return errorResponse(c, http.StatusBadRequest, codeInvalidFields,
+		err.Error())
+}
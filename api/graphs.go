@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+var errGraphsTooLarge = errors.New("graphs too large")
+
+// Graph is a dashboard graph of a single CES metric.
+type Graph struct {
+	ID         string      `json:"id,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Type       string      `json:"type"`
+	Namespace  string      `json:"namespace"`
+	MetricName string      `json:"metric_name"`
+	Dimensions []Dimension `json:"dimensions,omitempty"`
+}
+
+// Dimension is a CES metric dimension.
+type Dimension = core.Dimension
+
+// graphTypes are known graph types. They must be kept in sync with the
+// graphs schema.
+var graphTypes = map[string]bool{
+	"line": true,
+	"bar":  true,
+	"area": true,
+}
+
+// checkGraphsSize checks that graphs JSON size doesn't exceed the limit.
+func (s *Server) checkGraphsSize(graphs []byte) error {
+	if s.config.MaxGraphsSize > 0 && len(graphs) > s.config.MaxGraphsSize {
+		return fmt.Errorf("%w: graphs size %d bytes exceeds limit of %d bytes",
+			errGraphsTooLarge, len(graphs), s.config.MaxGraphsSize)
+	}
+	return nil
+}
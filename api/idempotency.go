@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	maxIdempotencyKeyLen = 255
+)
+
+var errIdempotencyKeyReused = errors.New(
+	"idempotency key is already used with another request")
+
+type idempotencyKey struct {
+	RequestHash string          `db:"request_hash"`
+	Response    json.RawMessage `db:"response"`
+	CreatedAt   time.Time       `db:"created_at"`
+}
+
+func requestHash(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// doIdempotent runs fn within tx at most once per owner idempotency key and
+// returns its JSON marshalled result. Key row is inserted before fn runs in
+// the same tx, so concurrent requests with the same key are blocked on the
+// row until the first one finishes, and then they replay its stored result.
+// Request hash detects the key reuse with another request.
+func (s *Server) doIdempotent(ctx context.Context, ownerID, key, hash string,
+	fn func(tx *goqu.TxDatabase) (interface{}, error)) (json.RawMessage,
+	error) {
+
+	where := goqu.Ex{"user_id": ownerID, "key": key}
+
+	var res json.RawMessage
+
+	err := withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+		_, err := tx.Insert("idempotency_key").
+			Cols("user_id", "key", "request_hash").
+			Vals(goqu.Vals{ownerID, key, hash}).
+			OnConflict(goqu.DoNothing()).Executor().ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		var ik idempotencyKey
+
+		_, err = tx.Select("request_hash", "response", "created_at").
+			From("idempotency_key").Where(where).ForUpdate(exp.Wait).
+			Executor().ScanStructContext(ctx, &ik)
+		if err != nil {
+			return err
+		}
+
+		if !isNullJSON(ik.Response) &&
+			time.Since(ik.CreatedAt) < s.config.IdempotencyKeyTTL {
+			if ik.RequestHash != hash {
+				return errIdempotencyKeyReused
+			}
+			res = ik.Response
+			return nil
+		}
+
+		v, err := fn(tx)
+		if err != nil {
+			return err
+		}
+
+		res, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Update("idempotency_key").Set(goqu.Record{
+			"request_hash": hash,
+			"response":     goqu.L("?::jsonb", string(res)),
+			"created_at":   goqu.L("now()"),
+		}).Where(where).Executor().ExecContext(ctx)
+
+		return err
+	})
+
+	return res, err
+}
+
+// sendIdempotentResponse sends JSON result stored for idempotency key.
+func sendIdempotentResponse(c *fiber.Ctx, res json.RawMessage) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(res)
+}
+
+// createDashboardIdempotent creates dashboard at most once per user
+// idempotency key.
+func (s *Server) createDashboardIdempotent(c *fiber.Ctx, userID, key string,
+	d Dashboard) error {
+
+	if len(key) > maxIdempotencyKeyLen {
+		return errorResponse(c, http.StatusBadRequest,
+			"invalid_idempotency_key", "idempotency key is too long")
+	}
+
+	ctx := c.UserContext()
+
+	res, err := s.doIdempotent(ctx, userID, key, requestHash(c.Body()),
+		func(tx *goqu.TxDatabase) (interface{}, error) {
+			errs, err := s.validateDashboard(ctx, userID, d)
+			if err != nil {
+				return nil, err
+			}
+			if len(errs) > 0 {
+				return nil, validationErrors(errs)
+			}
+
+			id, err := insertDashboardTx(ctx, tx, userID, d)
+			if err != nil {
+				return nil, err
+			}
+
+			return AddDashboardsRes{ID: id}, nil
+		})
+	if err != nil {
+		if errors.Is(err, errIdempotencyKeyReused) {
+			return errorResponse(c, http.StatusUnprocessableEntity,
+				"idempotency_key_reused", err.Error())
+		}
+		var verrs validationErrors
+		if errors.As(err, &verrs) {
+			return validationErrorResponse(c, verrs)
+		}
+		return internalError(c, "failed to insert dashboard to db", err)
+	}
+
+	return sendIdempotentResponse(c, res)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ipLimiterCleanupInterval is an interval between removals of idle IP
+// buckets.
+const ipLimiterCleanupInterval = time.Minute
+
+type ipBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ipLimiter is a token bucket rate limiter per client IP. Buckets refilled to
+// the burst are idle and removed periodically, so memory is bounded by IPs
+// active within the refill time.
+type ipLimiter struct {
+	rate  float64
+	burst float64
+
+	mx          sync.Mutex
+	buckets     map[string]*ipBucket
+	lastCleanup time.Time
+}
+
+// newIPLimiter creates new ipLimiter allowing rate requests per second with
+// bursts of burst requests.
+func newIPLimiter(rate, burst int) *ipLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &ipLimiter{
+		rate:        float64(rate),
+		burst:       float64(burst),
+		buckets:     map[string]*ipBucket{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// allow takes token of the IP. If there is no token it returns time after
+// which the token will be available.
+func (l *ipLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if now.Sub(l.lastCleanup) >= ipLimiterCleanupInterval {
+		l.cleanup(now)
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &ipBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate *
+			float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+func (l *ipLimiter) cleanup(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// limitIPs rejects requests of client IPs exceeding the rate limit with 429.
+// It's applied before auth, so unauthenticated floods don't amplify to IAM
+// calls. Health checks and metrics aren't limited.
+func (s *Server) limitIPs(c *fiber.Ctx) error {
+	switch c.Path() {
+	case "/health-check", "/ready", "/metrics":
+		return c.Next()
+	}
+
+	ok, wait := s.ipLimiter.allow(c.IP(), time.Now())
+	if !ok {
+		c.Set(fiber.HeaderRetryAfter,
+			strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return errorResponse(c, http.StatusTooManyRequests, "rate_limited",
+			"too many requests")
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,14 @@
+//go:build !gojson
+// +build !gojson
+
+package api
+
+import "encoding/json"
+
+// JSONEncoder and JSONDecoder are JSON functions of Fiber responses and
+// request body parsing. They are encoding/json, build with gojson tag to use
+// goccy/go-json instead.
+var (
+	JSONEncoder = json.Marshal
+	JSONDecoder = json.Unmarshal
+)
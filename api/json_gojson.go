@@ -0,0 +1,14 @@
+//go:build gojson
+// +build gojson
+
+package api
+
+import json "github.com/goccy/go-json"
+
+// JSONEncoder and JSONDecoder are goccy/go-json functions of Fiber responses
+// and request body parsing. They are faster on large lists and keep
+// json.RawMessage values, e.g. graphs, as encoding/json does.
+var (
+	JSONEncoder = json.Marshal
+	JSONDecoder = json.Unmarshal
+)
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchDashboardsRes returns dashboards list of n dashboards with m graphs
+// each, as GET /dashboards responds with all fields.
+func benchDashboardsRes(n, m int) DashboardsRes {
+	gs := make([]string, m)
+	for i := range gs {
+		gs[i] = fmt.Sprintf(`{"id":"%016x","title":"Graph %d","type":"line",`+
+			`"namespace":"SYS.ECS","metric_name":"cpu_util","dimensions":`+
+			`[{"name":"instance_id","value":"instance-%d"}]}`, i, i, i)
+	}
+	graphs := json.RawMessage("[" + strings.Join(gs, ",") + "]")
+
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	res := DashboardsRes{Dashboards: make([]map[string]interface{}, n)}
+
+	for i := range res.Dashboards {
+		res.Dashboards[i] = dashboardFieldValues(Dashboard{
+			ID:          i + 1,
+			Name:        fmt.Sprintf("Dashboard %d", i),
+			Graphs:      graphs,
+			IsFavorite:  i%10 == 0,
+			SortOrder:   i,
+			UpdatedAt:   at,
+			Description: "Production instances",
+		}, dashboardFields)
+	}
+
+	return res
+}
+
+// TestJSONEncoderCompatible checks that Fiber JSON functions of the build
+// produce and accept the same JSON as encoding/json. Run it with -tags
+// gojson too.
+func TestJSONEncoderCompatible(t *testing.T) {
+	res := benchDashboardsRes(10, 3)
+
+	got, err := JSONEncoder(res)
+	if err != nil {
+		t.Fatalf("JSONEncoder error: %v", err)
+	}
+
+	want, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("json.Marshal error: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("JSONEncoder output differs from encoding/json:\n%s\n%s",
+			got, want)
+	}
+
+	var d Dashboard
+
+	err = JSONDecoder([]byte(`{"name":"CPU","graphs":[{"type":"line"}]}`), &d)
+	if err != nil {
+		t.Fatalf("JSONDecoder error: %v", err)
+	}
+	if d.Name != "CPU" || string(d.Graphs) != `[{"type":"line"}]` {
+		t.Errorf("got decoded %+v, want name and raw graphs", d)
+	}
+}
+
+// BenchmarkJSONEncoder measures JSON encoding of max size dashboards page.
+// Compare results of default build and build with -tags gojson.
+func BenchmarkJSONEncoder(b *testing.B) {
+	res := benchDashboardsRes(maxDashboardsLimit, 10)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := JSONEncoder(res)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkJSONDecoder measures JSON decoding of dashboard create request.
+func BenchmarkJSONDecoder(b *testing.B) {
+	res := benchDashboardsRes(1, 50)
+
+	body, err := json.Marshal(res.Dashboards[0])
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var d Dashboard
+		err := JSONDecoder(body, &d)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
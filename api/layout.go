@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const codeInvalidLayout = "invalid_layout"
+
+// GridPosition is a graph position on the dashboard grid.
+type GridPosition struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// isNullJSON reports whether raw JSON is absent or null.
+func isNullJSON(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+// jsonbOrNull returns SQL expression of the raw JSON as jsonb or NULL.
+func jsonbOrNull(raw json.RawMessage) interface{} {
+	if isNullJSON(raw) {
+		return nil
+	}
+	return goqu.L("?::jsonb", string(raw))
+}
+
+// graphIDs returns set of graph IDs in the graphs JSON.
+func graphIDs(graphs json.RawMessage) map[string]bool {
+	ids := map[string]bool{}
+	gs, _ := parseRawGraphs(graphs)
+	for _, g := range gs {
+		if id := g.id(); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// validateLayout validates that layout is a map of graph ID to grid position
+// and all its graph IDs exist in graphs.
+func validateLayout(layout, graphs json.RawMessage) []ValidationError {
+	if isNullJSON(layout) {
+		return nil
+	}
+
+	var l map[string]GridPosition
+
+	err := json.Unmarshal(layout, &l)
+	if err != nil {
+		return []ValidationError{{Field: "layout",
+			Message: "must be a map of graph ID to grid position"}}
+	}
+
+	ids := graphIDs(graphs)
+
+	var errs []ValidationError
+
+	for id, p := range l {
+		field := fmt.Sprintf("layout[%q]", id)
+		if !ids[id] {
+			errs = append(errs, ValidationError{Field: field,
+				Message: "graph with such ID doesn't exist"})
+		}
+		if p.X < 0 || p.Y < 0 || p.W <= 0 || p.H <= 0 {
+			errs = append(errs, ValidationError{Field: field,
+				Message: "invalid grid position"})
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+
+	return errs
+}
+
+// pruneLayout removes positions of graphs which don't exist in graphs.
+func pruneLayout(layout, graphs json.RawMessage) (json.RawMessage, error) {
+	if isNullJSON(layout) {
+		return layout, nil
+	}
+
+	var l map[string]json.RawMessage
+
+	err := json.Unmarshal(layout, &l)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := graphIDs(graphs)
+
+	for id := range l {
+		if !ids[id] {
+			delete(l, id)
+		}
+	}
+
+	return json.Marshal(l)
+}
+
+func layoutErrorResponse(c *fiber.Ctx, errs []ValidationError) error {
+	return errorDetailsResponse(c, http.StatusBadRequest, codeInvalidLayout,
+		"dashboard layout is invalid", errs)
+}
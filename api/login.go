@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+type LoginReq struct {
+	Domain   string `json:"domain"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Project  string `json:"project"`
+}
+
+type LoginRes struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type iamName struct {
+	Name string `json:"name"`
+}
+
+type iamAuthReq struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User struct {
+					Name     string  `json:"name"`
+					Password string  `json:"password"`
+					Domain   iamName `json:"domain"`
+				} `json:"user"`
+			} `json:"password"`
+		} `json:"identity"`
+		Scope interface{} `json:"scope,omitempty"`
+	} `json:"auth"`
+}
+
+type iamAuthRes struct {
+	Token struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	} `json:"token"`
+}
+
+// login obtains token from IAM with user password. Credentials must never be
+// logged.
+func (s *Server) login(c *fiber.Ctx) error {
+
+	var lr LoginReq
+
+	err := json.Unmarshal(c.Body(), &lr)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidJSON,
+			"failed to JSON unmarshal login request")
+	}
+
+	if lr.Domain == "" || lr.Username == "" || lr.Password == "" {
+		return errorResponse(c, http.StatusBadRequest, "invalid_credentials",
+			"domain, username and password are required")
+	}
+
+	var ar iamAuthReq
+
+	ar.Auth.Identity.Methods = []string{"password"}
+	ar.Auth.Identity.Password.User.Name = lr.Username
+	ar.Auth.Identity.Password.User.Password = lr.Password
+	ar.Auth.Identity.Password.User.Domain.Name = lr.Domain
+
+	if lr.Project != "" {
+		ar.Auth.Scope = map[string]iamName{"project": {Name: lr.Project}}
+	} else {
+		ar.Auth.Scope = map[string]iamName{"domain": {Name: lr.Domain}}
+	}
+
+	body, err := json.Marshal(ar)
+	if err != nil {
+		return internalError(c, "failed to JSON marshal IAM auth request", err)
+	}
+
+	r, err := http.NewRequestWithContext(c.UserContext(), http.MethodPost,
+		s.config.IAMAPI+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return internalError(c, "failed to create http request", err)
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(r)
+	if err != nil {
+		logger.Warnf("[login] failed to do IAM request: %v", err)
+		return errorResponse(c, http.StatusBadGateway, codeIAMUnavailable,
+			"failed to request token")
+	}
+
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 500:
+		logger.Warnf("[login] IAM responded with status %d", res.StatusCode)
+		return errorResponse(c, http.StatusBadGateway, codeIAMUnavailable,
+			"unable to request token")
+	case res.StatusCode == http.StatusUnauthorized:
+		return errorResponse(c, http.StatusUnauthorized, "invalid_credentials",
+			"invalid credentials")
+	case res.StatusCode == http.StatusForbidden:
+		return errorResponse(c, http.StatusForbidden, "access_denied",
+			"access denied")
+	case res.StatusCode != http.StatusCreated &&
+		res.StatusCode != http.StatusOK:
+		return errorResponse(c, http.StatusBadRequest, "token_request_rejected",
+			"token request rejected")
+	}
+
+	token := res.Header.Get(core.HeaderXSubjectToken)
+	if token == "" {
+		return errorResponse(c, http.StatusBadGateway, codeIAMUnavailable,
+			"token is absent in IAM response")
+	}
+
+	var iar iamAuthRes
+
+	err = json.NewDecoder(res.Body).Decode(&iar)
+	if err != nil {
+		return errorResponse(c, http.StatusBadGateway, codeIAMUnavailable,
+			"failed to unmarshal IAM auth response")
+	}
+
+	return c.JSON(LoginRes{
+		Token:     token,
+		ExpiresAt: iar.Token.ExpiresAt,
+	})
+}
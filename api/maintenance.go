@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// maintenanceRetryAfter is Retry-After seconds of writes rejected in
+// maintenance mode.
+const maintenanceRetryAfter = 60
+
+type MaintenanceReq struct {
+	Enabled bool `json:"enabled"`
+}
+
+type MaintenanceRes struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (s *Server) inMaintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) == 1
+}
+
+// SetMaintenance turns maintenance mode on or off. It's safe to call
+// concurrently with requests handling.
+func (s *Server) SetMaintenance(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.maintenance, v)
+}
+
+// rejectWritesInMaintenance responds with 503 to mutating requests while
+// maintenance mode is on. Safe requests pass through.
+func (s *Server) rejectWritesInMaintenance(c *fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return c.Next()
+	}
+
+	if s.inMaintenance() {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(maintenanceRetryAfter))
+		return errorResponse(c, http.StatusServiceUnavailable, "maintenance",
+			"dashboards are read-only during maintenance, try again later")
+	}
+
+	return c.Next()
+}
+
+func (s *Server) adminGetMaintenance(c *fiber.Ctx) error {
+	return c.JSON(MaintenanceRes{Enabled: s.inMaintenance()})
+}
+
+// adminSetMaintenance turns maintenance mode on or off at runtime.
+func (s *Server) adminSetMaintenance(c *fiber.Ctx) error {
+	var r MaintenanceReq
+
+	err := json.Unmarshal(c.Body(), &r)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal maintenance"+
+			" request", err)
+	}
+
+	s.SetMaintenance(r.Enabled)
+
+	logger.Warnf("[admin] maintenance mode enabled=%t", r.Enabled)
+
+	return c.JSON(MaintenanceRes{Enabled: r.Enabled})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sony/gobreaker"
+)
+
+// breakerStateValue returns circuit breaker state metric value.
+func breakerStateValue(st gobreaker.State) int {
+	switch st {
+	case gobreaker.StateHalfOpen:
+		return 1
+	case gobreaker.StateOpen:
+		return 2
+	}
+	return 0
+}
+
+// metrics responds with service metrics in Prometheus text format.
+func (s *Server) metrics(c *fiber.Ctx) error {
+	var b strings.Builder
+
+	if cb := s.ces.Breaker; cb != nil {
+		cnt := cb.Counts()
+
+		fmt.Fprintf(&b, "# HELP ces_circuit_breaker_state CES circuit"+
+			" breaker state: 0 closed, 1 half-open, 2 open.\n")
+		fmt.Fprintf(&b, "# TYPE ces_circuit_breaker_state gauge\n")
+		fmt.Fprintf(&b, "ces_circuit_breaker_state{name=%q} %d\n",
+			cb.Name(), breakerStateValue(cb.State()))
+
+		fmt.Fprintf(&b, "# HELP ces_circuit_breaker_consecutive_failures"+
+			" CES consecutive failures in the current breaker interval.\n")
+		fmt.Fprintf(&b, "# TYPE ces_circuit_breaker_consecutive_failures"+
+			" gauge\n")
+		fmt.Fprintf(&b, "ces_circuit_breaker_consecutive_failures{name=%q}"+
+			" %d\n", cb.Name(), cnt.ConsecutiveFailures)
+	}
+
+	s.authMetrics.write(&b, time.Now())
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+
+	return c.SendString(b.String())
+}
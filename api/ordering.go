@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReorderReq struct {
+	IDs []int `json:"ids"`
+}
+
+type FavoriteRes struct {
+	IsFavorite bool `json:"is_favorite"`
+}
+
+// reorderDashboards sets sort order of the user dashboards to their
+// positions in the request list in one transaction.
+func (s *Server) reorderDashboards(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	var rr ReorderReq
+
+	err := json.Unmarshal(c.Body(), &rr)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidJSON,
+			"failed to JSON unmarshal reorder request")
+	}
+
+	if len(rr.IDs) == 0 {
+		return errorResponse(c, http.StatusBadRequest, "invalid_ids",
+			"ids must not be empty")
+	}
+
+	seen := map[int]bool{}
+	for _, id := range rr.IDs {
+		if seen[id] {
+			return errorResponse(c, http.StatusBadRequest, "invalid_ids",
+				"ids must be unique, duplicate "+strconv.Itoa(id))
+		}
+		seen[id] = true
+	}
+
+	ctx := c.UserContext()
+
+	err = withTx(ctx, s.db, func(tx *goqu.TxDatabase) error {
+		for i, id := range rr.IDs {
+			res, err := tx.Update("dashboard").
+				Set(goqu.Record{
+					"sort_order": i,
+					"updated_at": goqu.L("now()"),
+				}).
+				Where(goqu.Ex{"id": id, "user_id": userID}).
+				Executor().ExecContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			updated, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if updated == 0 {
+				return errDashboardNotFound
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errDashboardNotFound) {
+			return errorResponse(c, http.StatusNotFound,
+				codeDashboardNotFound, err.Error())
+		}
+		return internalError(c, "failed to reorder dashboards in db", err)
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// toggleFavorite toggles the user dashboard favorite flag.
+func (s *Server) toggleFavorite(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	var fr FavoriteRes
+
+	found, err := s.db.Update("dashboard").
+		Set(goqu.Record{
+			"is_favorite": goqu.L("not is_favorite"),
+			"updated_at":  goqu.L("now()"),
+		}).
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Returning("is_favorite").Executor().
+		ScanValContext(c.UserContext(), &fr.IsFavorite)
+	if err != nil {
+		return internalError(c, "failed to toggle dashboard favorite in db",
+			err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	return c.JSON(fr)
+}
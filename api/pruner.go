@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// RunPruner periodically deletes stale rows: expired idempotency keys,
+// snapshots and audit log entries older than retention periods. It blocks
+// until ctx is done.
+func (s *Server) RunPruner(ctx context.Context) {
+	if s.config.PruneInterval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(s.config.PruneInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.prune(ctx)
+		}
+	}
+}
+
+func (s *Server) prune(ctx context.Context) {
+	now := time.Now()
+
+	s.pruneTable(ctx, "idempotency_key", goqu.C("created_at").Lt(
+		now.Add(-s.config.IdempotencyKeyTTL)))
+
+	if s.config.SnapshotRetention > 0 {
+		s.pruneTable(ctx, "dashboard_snapshot", goqu.C("created_at").Lt(
+			now.Add(-s.config.SnapshotRetention)))
+	}
+
+	if s.config.AuditRetention > 0 {
+		s.pruneAudit(ctx)
+	}
+}
+
+func (s *Server) pruneTable(ctx context.Context, table string,
+	where goqu.Expression) {
+
+	res, err := s.db.Delete(table).Where(where).Executor().ExecContext(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Errorf("[pruner] failed to prune %s: %v", table, err)
+		}
+		return
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		logger.Errorf("[pruner] failed to get %s pruned rows count: %v",
+			table, err)
+		return
+	}
+
+	logger.Infof("[pruner] pruned %d rows from %s", n, table)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IsReady returns true if the server accepts traffic.
+func (s *Server) IsReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// SetReady sets server readiness reported by /ready. Server is ready after
+// startup checks pass and isn't ready from the start of shutdown, so load
+// balancer drains it before connections are closed.
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// CheckIAM checks IAM is reachable. Any IAM response except 5xx means it's
+// reachable: tokens aren't verified here.
+func (s *Server) CheckIAM(ctx context.Context) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		s.config.IAMAPI, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	res, err := s.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("failed to do http request: %w", err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("IAM responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// readyCheck responds with 503 until the server is ready. Unlike
+// health-check, which is liveness, it's readiness.
+func (s *Server) readyCheck(c *fiber.Ctx) error {
+	if !s.IsReady() {
+		return c.SendStatus(http.StatusServiceUnavailable)
+	}
+	return c.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// redactURL masks values of sensitive query parameters and user password in
+// the URL, so it can be safely logged.
+func (s *Server) redactURL(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "<unparsable url>"
+	}
+
+	if pu.RawQuery != "" {
+		q := pu.Query()
+		for k, vs := range q {
+			if s.redactQueryKeys[strings.ToLower(k)] {
+				for i := range vs {
+					vs[i] = redacted
+				}
+			}
+		}
+		pu.RawQuery = q.Encode()
+	}
+
+	return pu.Redacted()
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// currentGraphsVersion is a version of graphs format used when payload
+// doesn't specify it.
+const currentGraphsVersion = 1
+
+//go:embed schemas
+var schemasFS embed.FS
+
+// graphsSchemas are compiled graphs schemas by format version. Incompatible
+// graphs format changes go to the new schema version.
+var graphsSchemas = map[int]*jsonschema.Schema{
+	1: mustCompileSchema("schemas/graphs.v1.json"),
+}
+
+func mustCompileSchema(path string) *jsonschema.Schema {
+	data, err := schemasFS.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read schema %s: %v", path, err))
+	}
+
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+
+	err = c.AddResource(path, bytes.NewReader(data))
+	if err != nil {
+		panic(fmt.Sprintf("failed to add schema %s: %v", path, err))
+	}
+
+	return c.MustCompile(path)
+}
+
+// supportedGraphsVersions returns sorted list of supported graphs format
+// versions.
+func supportedGraphsVersions() []string {
+	var vs []int
+	for v := range graphsSchemas {
+		vs = append(vs, v)
+	}
+	sort.Ints(vs)
+
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		ss[i] = strconv.Itoa(v)
+	}
+	return ss
+}
+
+// schemaField converts JSON pointer of the instance location to field path,
+// e.g. /0/dimensions/1 to graphs[0].dimensions[1].
+func schemaField(root, ptr string) string {
+	field := root
+	for _, t := range strings.Split(ptr, "/")[1:] {
+		t = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+		if _, err := strconv.Atoi(t); err == nil {
+			field += "[" + t + "]"
+		} else {
+			field += "." + t
+		}
+	}
+	return field
+}
+
+// schemaErrors returns leaf schema validation errors as validation errors of
+// fields under the root.
+func schemaErrors(root string, ve *jsonschema.ValidationError) []ValidationError {
+	if len(ve.Causes) == 0 {
+		return []ValidationError{{
+			Field:   schemaField(root, ve.InstanceLocation),
+			Message: ve.Message,
+		}}
+	}
+
+	var errs []ValidationError
+	for _, c := range ve.Causes {
+		errs = append(errs, schemaErrors(root, c)...)
+	}
+	return errs
+}
+
+// validateGraphs validates graphs against JSON schema of the graphs format
+// version and checks that there are no more than maxGraphs graphs. Zero
+// version means current one, zero maxGraphs means no limit.
+func validateGraphs(graphs json.RawMessage,
+	version, maxGraphs int) []ValidationError {
+
+	if version == 0 {
+		version = currentGraphsVersion
+	}
+
+	schema, ok := graphsSchemas[version]
+	if !ok {
+		return []ValidationError{{Field: "graphs_version",
+			Message: fmt.Sprintf("unsupported graphs version %d, supported"+
+				" versions: %s", version,
+				strings.Join(supportedGraphsVersions(), ", "))}}
+	}
+
+	if isNullJSON(graphs) {
+		return nil
+	}
+
+	var v interface{}
+
+	err := json.Unmarshal(graphs, &v)
+	if err != nil {
+		return []ValidationError{{Field: "graphs",
+			Message: "must be valid JSON"}}
+	}
+
+	err = schema.Validate(v)
+	if err != nil {
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			return []ValidationError{{Field: "graphs", Message: err.Error()}}
+		}
+		return schemaErrors("graphs", ve)
+	}
+
+	gs, _ := v.([]interface{})
+
+	if maxGraphs > 0 && len(gs) > maxGraphs {
+		return []ValidationError{{Field: "graphs",
+			Message: fmt.Sprintf("dashboard has %d graphs, limit is %d",
+				len(gs), maxGraphs)}}
+	}
+
+	return duplicateGraphIDs(gs)
+}
+
+// duplicateGraphIDs returns validation errors of graphs having the same ID
+// as one of previous graphs.
+func duplicateGraphIDs(gs []interface{}) []ValidationError {
+	var errs []ValidationError
+
+	seen := map[string]bool{}
+
+	for i, g := range gs {
+		m, _ := g.(map[string]interface{})
+		id, _ := m["id"].(string)
+		if id == "" {
+			continue
+		}
+		if seen[id] {
+			errs = append(errs, ValidationError{
+				Field:   fmt.Sprintf("graphs[%d].id", i),
+				Message: fmt.Sprintf("duplicate graph id %q", id)})
+		}
+		seen[id] = true
+	}
+
+	return errs
+}
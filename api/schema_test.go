@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateGraphsDuplicateIDs(t *testing.T) {
+	withID := func(id, metric string) string {
+		return `{"id": "` + id + `", "type": "line", "namespace": "SYS.ECS",` +
+			` "metric_name": "` + metric + `"}`
+	}
+
+	graphs := "[" + withID("a", "cpu_util") + "," + withID("b", "mem_util") +
+		"," + withID("a", "disk_read_bytes_rate") + "," + cpuGraph + "," +
+		withID("b", "cpu_util") + "]"
+
+	errs := validateGraphs(json.RawMessage(graphs), 0, 0)
+
+	want := []ValidationError{
+		{Field: "graphs[2].id", Message: `duplicate graph id "a"`},
+		{Field: "graphs[4].id", Message: `duplicate graph id "b"`},
+	}
+
+	if len(errs) != len(want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("got error %v, want %v", errs[i], want[i])
+		}
+	}
+
+	errs = validateGraphs(json.RawMessage("["+withID("a", "cpu_util")+","+
+		cpuGraph+","+cpuGraph+"]"), 0, 0)
+	if len(errs) != 0 {
+		t.Errorf("graphs without duplicate IDs got errors %v", errs)
+	}
+}
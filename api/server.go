@@ -0,0 +1,389 @@
+package api
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// Config holds settings of the Server.
+type Config struct {
+	IAMAPI       string
+	CESAPI       string
+	CESProjectID string
+
+	// CatalogCacheTTL is how long CES metrics catalog is cached per user.
+	CatalogCacheTTL time.Duration
+
+	// RedactQueryKeys are query parameters which values are masked in
+	// logged URLs.
+	RedactQueryKeys []string
+
+	// MaxGraphsSize is max size of dashboard graphs JSON in bytes.
+	MaxGraphsSize int
+
+	// MaxGraphs is max number of graphs per dashboard. Zero means no limit.
+	MaxGraphs int
+
+	// BatchQueryRateLimit is max number of CES batch queries per user per
+	// minute.
+	BatchQueryRateLimit int
+
+	// CESLogSampling is N to log 1 of every N CES requests with info level.
+	// Zero disables sampling.
+	CESLogSampling int
+
+	// CSRFEnabled enables CSRF protection of dashboard mutations.
+	CSRFEnabled bool
+
+	// IdempotencyKeyTTL is a duration during which repeated dashboard create
+	// request with the same Idempotency-Key replays the original response.
+	IdempotencyKeyTTL time.Duration
+
+	// PruneInterval is an interval between stale rows pruning runs. Zero
+	// disables pruning.
+	PruneInterval time.Duration
+
+	// SnapshotRetention is an age after which dashboard snapshots are
+	// pruned. Zero keeps snapshots forever.
+	SnapshotRetention time.Duration
+
+	// AuditRetention is an age after which audit log entries are pruned.
+	// Zero keeps audit log forever.
+	AuditRetention time.Duration
+
+	// DBTimeout limits processing time of dashboard handlers.
+	DBTimeout time.Duration
+
+	// CESTimeout limits processing time of CES handlers.
+	CESTimeout time.Duration
+
+	// MaxTimeRange is max CES query time range. Zero means no limit.
+	MaxTimeRange time.Duration
+
+	// CESPathAllowlist are regular expressions of CES paths which proxy
+	// forwards. Path must fully match one of them.
+	CESPathAllowlist []string
+
+	// CESQuerySignPaths are regular expressions of CES paths which requests
+	// are signed with query parameters regardless of the signer mode. Path
+	// must fully match one of them.
+	CESQuerySignPaths []string
+
+	// CESBreakerFailures is a number of consecutive CES failures which opens
+	// the circuit breaker. Zero disables the breaker.
+	CESBreakerFailures int
+
+	// CESSharedBodyLimit is max size of CES proxy response body shared by
+	// concurrent identical requests. Larger responses are streamed to every
+	// request separately. Zero disables sharing.
+	CESSharedBodyLimit int
+
+	// CESBreakerCooldown is a duration of open circuit breaker state after
+	// which CES is probed again.
+	CESBreakerCooldown time.Duration
+
+	// Commit and BuildTime identify the running build.
+	Commit    string
+	BuildTime string
+
+	// StartTime is a process start time to report uptime.
+	StartTime time.Time
+
+	// AdminToken is a credential of admin routes. Admin routes reject all
+	// requests if it's empty.
+	AdminToken string
+
+	// DashboardDataCacheTTL is how long dashboards data is cached. Zero
+	// disables the cache.
+	DashboardDataCacheTTL time.Duration
+
+	// DashboardDataCacheSize is max number of cached dashboards data items.
+	DashboardDataCacheSize int
+
+	// DefaultTemplateID is ID of the template seeding dashboards created
+	// without graphs with template=default. Zero disables seeding.
+	DefaultTemplateID int
+
+	// IAMAlertPercent is a percent of auth requests finding IAM unavailable
+	// within a minute above which a warning is logged. Zero disables the
+	// warning.
+	IAMAlertPercent int
+
+	// IPRateLimit is max number of requests per second per client IP. Zero
+	// disables the limit.
+	IPRateLimit int
+
+	// IPRateBurst is max number of requests per client IP above the rate in
+	// a burst.
+	IPRateBurst int
+
+	// MaintenanceMode rejects dashboard writes from the start. It can be
+	// switched at runtime with admin API.
+	MaintenanceMode bool
+
+	// AuthTokenHeader is a header of IAM auth token. Authorization bearer
+	// header is a fallback. Defaults to X-Auth-Token.
+	AuthTokenHeader string
+
+	// MaxEventSubscribers is max number of change events subscribers per
+	// dashboard. Zero means no limit.
+	MaxEventSubscribers int
+
+	// CursorSecret is a key of pagination cursors signature. Random key is
+	// used if it's empty, so cursors become invalid after restart.
+	CursorSecret string
+}
+
+// Server serves dashboards API and proxies requests to SberCloud CES.
+type Server struct {
+	db       *goqu.Database
+	ces      *core.CESClient
+	verifier core.TokenVerifier
+	client   *http.Client
+	config   Config
+
+	redactQueryKeys map[string]bool
+	catalogCache    *cache
+	checkCache      *cache
+	cesLogSampler   *logger.Sampler
+	cursorSecret    []byte
+
+	// readDB serves read-only dashboard handlers. It's a replica if it's
+	// configured, so reads are eventually consistent: just written
+	// dashboard may be absent in the following read for a replication lag.
+	readDB *goqu.Database
+
+	cesPathAllowlist []*regexp.Regexp
+
+	events *eventBus
+
+	// auditPurging is 1 while audit log purge is running.
+	auditPurging int32
+
+	// maintenance is 1 while dashboard writes are rejected.
+	maintenance int32
+
+	// ready is 1 while the server accepts traffic.
+	ready int32
+
+	// ipLimiter is nil if per-IP rate limit is disabled.
+	ipLimiter *ipLimiter
+
+	// dataCache is nil if dashboards data cache is disabled.
+	dataCache *dataCache
+
+	// authMetrics counts IAM auth outcomes.
+	authMetrics *authMetrics
+
+	// cesFlight deduplicates concurrent identical CES proxy requests. It's
+	// nil if sharing is disabled.
+	cesFlight *cesFlight
+}
+
+// NewServer creates new Server. Read-only dashboard handlers use replica DB
+// if it isn't nil and primary db otherwise.
+func NewServer(db, replica *goqu.Database, signer core.Signer,
+	verifier core.TokenVerifier, client *http.Client, config Config) (
+	*Server, error) {
+	redactQueryKeys := map[string]bool{}
+	for _, k := range config.RedactQueryKeys {
+		redactQueryKeys[strings.ToLower(k)] = true
+	}
+
+	cursorSecret := []byte(config.CursorSecret)
+	if len(cursorSecret) == 0 {
+		cursorSecret = make([]byte, 32)
+		_, err := rand.Read(cursorSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate cursor secret: %w", err)
+		}
+	}
+
+	cesPathAllowlist, err := compileCESPaths(config.CESPathAllowlist)
+	if err != nil {
+		return nil, err
+	}
+
+	cesQuerySignPaths, err := compileCESPaths(config.CESQuerySignPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		db:              db,
+		readDB:          db,
+		verifier:        verifier,
+		client:          client,
+		config:          config,
+		redactQueryKeys: redactQueryKeys,
+		catalogCache:    newCache(config.CatalogCacheTTL),
+		checkCache:      newCache(checkCacheTTL),
+		authMetrics:     newAuthMetrics(config.IAMAlertPercent),
+		cesLogSampler:   logger.NewSampler(config.CESLogSampling),
+		cursorSecret:    cursorSecret,
+
+		cesPathAllowlist: cesPathAllowlist,
+
+		events: newEventBus(config.MaxEventSubscribers),
+	}
+
+	if replica != nil {
+		s.readDB = replica
+	}
+
+	s.SetMaintenance(config.MaintenanceMode)
+
+	if config.DashboardDataCacheTTL > 0 {
+		s.dataCache = newDataCache(config.DashboardDataCacheTTL,
+			config.DashboardDataCacheSize)
+	}
+
+	if config.CESSharedBodyLimit > 0 {
+		s.cesFlight = newCESFlight(config.CESSharedBodyLimit)
+	}
+
+	if config.IPRateLimit > 0 {
+		s.ipLimiter = newIPLimiter(config.IPRateLimit, config.IPRateBurst)
+	}
+
+	if s.config.AuthTokenHeader == "" {
+		s.config.AuthTokenHeader = core.HeaderXAuthToken
+	}
+
+	s.ces = &core.CESClient{
+		URL:       config.CESAPI,
+		ProjectID: config.CESProjectID,
+		Signer:    signer,
+		Client:    client,
+		OnRequest: s.logCESRequest,
+
+		QuerySignPaths: cesQuerySignPaths,
+	}
+
+	if config.CESBreakerFailures > 0 {
+		s.ces.Breaker = newCESBreaker(config)
+	}
+
+	return s, nil
+}
+
+// RegisterRoutes registers Server routes in the app.
+func (s *Server) RegisterRoutes(app *fiber.App) {
+	app.Use(traceRequests, resolveClientIP)
+
+	if s.ipLimiter != nil {
+		app.Use(s.limitIPs)
+	}
+
+	app.Get("/health-check", s.healthCheck)
+	app.Get("/ready", s.readyCheck)
+	app.Get("/metrics", s.metrics)
+	app.Get("/version", s.version)
+	app.Post("/auth/login", s.login)
+
+	cesTimeout := timeout(s.config.CESTimeout)
+	dbTimeout := timeout(s.config.DBTimeout)
+
+	// Templates are system data, so they are listed without auth.
+	app.Get("/templates", dbTimeout, s.listTemplates)
+
+	// Admin routes don't go through IAM auth and are only reachable with
+	// the admin token. Audit log purge is registered before the admin group, so it isn't
+	// limited by DB timeout: it may take long, but consists of short
+	// batches.
+	app.Post("/admin/audit/purge", s.adminAuth, s.adminPurgeAudit)
+
+	admin := app.Group("/admin", s.adminAuth, dbTimeout)
+
+	admin.Get("/dashboards", s.adminListDashboards)
+	admin.Post("/dashboards/:id/copy-to", s.adminCopyDashboard)
+	admin.Get("/maintenance", s.adminGetMaintenance)
+	admin.Put("/maintenance", s.adminSetMaintenance)
+
+	// Authenticated routes are grouped under their own prefixes, so
+	// requests of unknown routes get 404 without token instead of 401.
+	dashboards := app.Group("/dashboards", s.auth)
+
+	csrf := func(c *fiber.Ctx) error {
+		return c.Next()
+	}
+	if s.config.CSRFEnabled {
+		csrf = s.csrfProtection()
+	}
+
+	// Dashboard routes doing CES requests are registered before the
+	// group of DB routes to get CES timeout instead of DB one.
+	dashboards.Post("/:id/snapshot", cesTimeout, csrf,
+		s.rejectWritesInMaintenance, s.createSnapshot)
+
+	app.Get("/snapshots/:id", s.auth, dbTimeout, s.getSnapshot)
+
+	dashboards.Get("/:id/data", cesTimeout, s.dashboardData)
+
+	// Check is a read, so it isn't rejected in maintenance mode.
+	dashboards.Post("/:id/check", cesTimeout, csrf, s.checkDashboard)
+
+	// Events stream outlives any handler timeout.
+	dashboards.Get("/:id/events", s.dashboardEvents)
+
+	// CES requests of clients gone while waiting for upstream are aborted.
+	ces := app.Group("/ces", s.auth, cesTimeout, cancelOnDisconnect)
+
+	ces.Get("/catalog", s.cesCatalog)
+	ces.Get("/aggregate", s.cesAggregate)
+	ces.Post("/batch-query", limiter.New(limiter.Config{
+		Max:        s.config.BatchQueryRateLimit,
+		Expiration: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			userID, _ := c.Locals("userID").(string)
+			return userID
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return errorResponse(c, http.StatusTooManyRequests,
+				"rate_limited", "too many batch queries")
+		},
+	}), s.cesBatchQuery)
+	ces.Post("/test-query", s.cesTestQuery)
+	ces.Get("/*", s.proxyCES)
+
+	// GET routes serve HEAD as well with the same headers, e.g. ETag, and no
+	// body. Unsupported methods of known paths are responded by the router
+	// with 405 and Allow header listing methods registered for the path.
+	ds := dashboards.Group("", dbTimeout, csrf, s.rejectWritesInMaintenance)
+
+	ds.Get("", s.listDashboards)
+	ds.Get("/recent", s.recentDashboards)
+	ds.Get("/stats", s.dashboardsStats)
+	ds.Get("/:id", s.getDashboard)
+	ds.Get("/:id/history", s.dashboardHistory)
+	ds.Get("/:id/snapshots", s.listSnapshots)
+	ds.Get("/:id/diff", s.diffSnapshots)
+	ds.Get("/:id/graphs", s.listGraphs)
+	ds.Post("/:id/graphs", s.addGraph)
+	ds.Put("/:id/graphs/:gid", s.updateGraph)
+	ds.Delete("/:id/graphs/:gid", s.deleteGraph)
+	ds.Post("/:id/favorite", s.toggleFavorite)
+	ds.Put("/reorder", s.reorderDashboards)
+	ds.Delete("/:id", s.deleteDashboard)
+	ds.Post("", s.createDashboard)
+	ds.Post("/from-csv", s.createDashboardFromCSV)
+	ds.Post("/from-template/:id", s.createDashboardFromTemplate)
+	ds.Put("", s.updateDashboard)
+
+	app.Use(routeNotFound)
+}
+
+func (s *Server) healthCheck(c *fiber.Ctx) error {
+	return c.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const testUserID = "test-user"
+
+// testVerifier accepts any token as the test user.
+type testVerifier struct{}
+
+func (testVerifier) Verify(ctx context.Context, token string) (string,
+	error) {
+	return testUserID, nil
+}
+
+// newTestApp creates app serving Server routes without DB. CES requests go
+// to the cesURL and every CES path is allowed. Zero timeouts of the config
+// default to a minute.
+func newTestApp(t *testing.T, cesURL string, config Config) (*Server,
+	*fiber.App) {
+
+	t.Helper()
+
+	return newTestAppClient(t, cesURL, http.DefaultClient, config)
+}
+
+// newTestAppClient is newTestApp doing upstream requests with the client.
+func newTestAppClient(t *testing.T, cesURL string, client *http.Client,
+	config Config) (*Server, *fiber.App) {
+
+	t.Helper()
+
+	config.CESAPI = cesURL
+	config.CESProjectID = "project"
+	if config.CESPathAllowlist == nil {
+		config.CESPathAllowlist = []string{".*"}
+	}
+	if config.DBTimeout == 0 {
+		config.DBTimeout = time.Minute
+	}
+	if config.CESTimeout == 0 {
+		config.CESTimeout = time.Minute
+	}
+	if config.BatchQueryRateLimit == 0 {
+		config.BatchQueryRateLimit = 100
+	}
+
+	s, err := NewServer(nil, nil, core.Signer{Key: "key", Secret: "secret"},
+		testVerifier{}, client, config)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	app := newFiberApp()
+
+	s.RegisterRoutes(app)
+
+	return s, app
+}
+
+// newFiberApp creates app with error handling set up as in main.
+func newFiberApp() *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: ErrorHandler,
+		JSONEncoder:  JSONEncoder,
+		JSONDecoder:  JSONDecoder,
+
+		DisableStartupMessage: true,
+	})
+
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace:  true,
+		StackTraceHandler: StackTraceHandler,
+	}))
+
+	return app
+}
+
+// httpGet creates unauthenticated GET request to the app.
+func httpGet(target string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, target, nil)
+	return r
+}
+
+// newTestRequest creates authenticated request to the app.
+func newTestRequest(method, target string) *http.Request {
+	r, _ := http.NewRequest(method, target, nil)
+	r.Header.Set(core.HeaderXAuthToken, "token")
+	return r
+}
+
+// syncBuffer is a buffer safe for concurrent writes of the logger.
+type syncBuffer struct {
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs captures log output with the level until the test ends.
+func captureLogs(t *testing.T, l logger.Level) *syncBuffer {
+	t.Helper()
+
+	var buf syncBuffer
+
+	prev := logger.GetLevel()
+	logger.SetOutput(&buf)
+	logger.SetLevel(l)
+
+	t.Cleanup(func() {
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(prev)
+	})
+
+	return &buf
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+)
+
+const (
+	snapshotWindow      = 10 * time.Minute
+	snapshotPeriod      = "1"
+	snapshotFilter      = "average"
+	snapshotConcurrency = 4
+)
+
+type Snapshot struct {
+	ID          int             `db:"id" json:"id"`
+	DashboardID int             `db:"dashboard_id" json:"dashboard_id"`
+	Graphs      json.RawMessage `db:"graphs" json:"graphs,omitempty"`
+	Data        json.RawMessage `db:"data" json:"data,omitempty"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+}
+
+type SnapshotData struct {
+	From   int64           `json:"from"`
+	To     int64           `json:"to"`
+	Values []SnapshotValue `json:"values"`
+}
+
+// SnapshotValue is the latest value of the graph metric.
+type SnapshotValue struct {
+	GraphID    string      `json:"graph_id,omitempty"`
+	Namespace  string      `json:"namespace"`
+	MetricName string      `json:"metric_name"`
+	Dimensions []Dimension `json:"dimensions,omitempty"`
+	Unit       string      `json:"unit,omitempty"`
+	Value      *float64    `json:"value"`
+	Timestamp  int64       `json:"timestamp,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+type SnapshotsRes struct {
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+type SnapshotRes struct {
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type AddSnapshotRes struct {
+	ID int `json:"id"`
+}
+
+// snapshotValues fetches the latest values of graphs metrics. Metrics are
+// queried in CES batches concurrently with bounded concurrency.
+func (s *Server) snapshotValues(ctx context.Context, gs []Graph,
+	from, to time.Time) []SnapshotValue {
+
+	vs := make([]SnapshotValue, len(gs))
+
+	for i, g := range gs {
+		vs[i] = SnapshotValue{
+			GraphID:    g.ID,
+			Namespace:  g.Namespace,
+			MetricName: g.MetricName,
+			Dimensions: g.Dimensions,
+		}
+
+		if len(g.Dimensions) == 0 {
+			vs[i].Error = errNoDimensions
+		}
+	}
+
+	batches := graphBatches(gs)
+
+	metrics, errs := core.FanOut(ctx, batches, snapshotConcurrency,
+		func(ctx context.Context, b []int) (
+			map[string]BatchQueryResMetric, error) {
+
+			bqr := BatchQueryReq{
+				From:   from.UnixNano() / int64(time.Millisecond),
+				To:     to.UnixNano() / int64(time.Millisecond),
+				Period: snapshotPeriod,
+				Filter: snapshotFilter,
+			}
+
+			for _, i := range b {
+				bqr.Metrics = append(bqr.Metrics, BatchQueryMetric{
+					Namespace:  gs[i].Namespace,
+					MetricName: gs[i].MetricName,
+					Dimensions: gs[i].Dimensions,
+				})
+			}
+
+			res, err := s.ces.BatchQueryMetricData(ctx, bqr)
+			if err != nil {
+				return nil, err
+			}
+
+			ms := map[string]BatchQueryResMetric{}
+			for _, m := range res.Metrics {
+				ms[metricKey(m.Namespace, m.MetricName, m.Dimensions)] = m
+			}
+
+			return ms, nil
+		})
+
+	for bi, b := range batches {
+		for _, i := range b {
+			if errs[bi] != nil {
+				vs[i].Error = errs[bi].Error()
+				continue
+			}
+			m, ok := metrics[bi][metricKey(gs[i].Namespace,
+				gs[i].MetricName, gs[i].Dimensions)]
+			if !ok || len(m.Datapoints) == 0 {
+				vs[i].Error = "no data"
+				continue
+			}
+			dp := m.Datapoints[len(m.Datapoints)-1]
+			vs[i].Unit = m.Unit
+			vs[i].Value = dp.Value(snapshotFilter)
+			vs[i].Timestamp = dp.Timestamp
+		}
+	}
+
+	return vs
+}
+
+func (s *Server) createSnapshot(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	ctx := c.UserContext()
+
+	var graphs json.RawMessage
+
+	found, err := s.db.Select("graphs").From("dashboard").
+		Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
+		Executor().ScanValContext(ctx, &graphs)
+	if err != nil {
+		return internalError(c, "failed to get dashboard from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, codeDashboardNotFound,
+			"dashboard not found")
+	}
+
+	var gs []Graph
+
+	if !isNullJSON(graphs) {
+		err = json.Unmarshal(graphs, &gs)
+		if err != nil {
+			return errorResponse(c, http.StatusUnprocessableEntity,
+				codeInvalidGraphs, "dashboard graphs are invalid")
+		}
+	} else {
+		graphs = json.RawMessage("[]")
+	}
+
+	to := time.Now()
+	from := to.Add(-snapshotWindow)
+
+	data, err := json.Marshal(SnapshotData{
+		From:   from.UnixNano() / int64(time.Millisecond),
+		To:     to.UnixNano() / int64(time.Millisecond),
+		Values: s.snapshotValues(ctx, gs, from, to),
+	})
+	if err != nil {
+		return internalError(c, "failed to JSON marshal snapshot data", err)
+	}
+
+	var id int
+
+	_, err = s.db.Insert("dashboard_snapshot").
+		Cols("dashboard_id", "user_id", "graphs", "data").
+		Vals(goqu.Vals{dashboardID, userID,
+			goqu.L("?::jsonb", string(graphs)),
+			goqu.L("?::jsonb", string(data))}).
+		Returning("id").Executor().ScanValContext(ctx, &id)
+	if err != nil {
+		return internalError(c, "failed to insert snapshot to DB", err)
+	}
+
+	return c.JSON(AddSnapshotRes{ID: id})
+}
+
+func (s *Server) listSnapshots(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	dashboardID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, codeInvalidDashboardID,
+			"failed to parse dashboard ID")
+	}
+
+	ss := []Snapshot{}
+
+	err = s.db.Select("id", "dashboard_id", "created_at").
+		From("dashboard_snapshot").
+		Where(goqu.Ex{"dashboard_id": dashboardID, "user_id": userID}).
+		Order(goqu.C("created_at").Desc(), goqu.C("id").Desc()).
+		Executor().ScanStructsContext(c.UserContext(), &ss)
+	if err != nil {
+		return internalError(c, "failed to get snapshots from DB", err)
+	}
+
+	return c.JSON(SnapshotsRes{Snapshots: ss})
+}
+
+func (s *Server) getSnapshot(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	snapshotID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_snapshot_id",
+			"failed to parse snapshot ID")
+	}
+
+	var sn Snapshot
+
+	found, err := s.db.Select("id", "dashboard_id", "graphs", "data",
+		"created_at").From("dashboard_snapshot").
+		Where(goqu.Ex{"id": snapshotID, "user_id": userID}).
+		Executor().ScanStructContext(c.UserContext(), &sn)
+	if err != nil {
+		return internalError(c, "failed to get snapshot from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, "snapshot_not_found",
+			"snapshot not found")
+	}
+
+	return c.JSON(SnapshotRes{Snapshot: sn})
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+type DashboardStatsRes struct {
+	Dashboards    int        `db:"dashboards" json:"dashboards"`
+	Favorites     int        `db:"favorites" json:"favorites"`
+	Graphs        int        `db:"graphs" json:"graphs"`
+	LastUpdatedAt *time.Time `db:"last_updated_at" json:"last_updated_at"`
+}
+
+// dashboardsStats responds with the user dashboards stats aggregated by one
+// query. Graphs are counted by lengths of graphs arrays.
+func (s *Server) dashboardsStats(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	var res DashboardStatsRes
+
+	_, err := s.readDB.Select(
+		goqu.COUNT(goqu.Star()).As("dashboards"),
+		goqu.L("count(*) filter (where is_favorite)").As("favorites"),
+		goqu.L("coalesce(sum(case when jsonb_typeof(graphs) = 'array'"+
+			" then jsonb_array_length(graphs) else 0 end), 0)").As("graphs"),
+		goqu.MAX("updated_at").As("last_updated_at"),
+	).From("dashboard").Where(goqu.Ex{"user_id": userID}).
+		Executor().ScanStructContext(c.UserContext(), &res)
+	if err != nil {
+		return internalError(c, "failed to get dashboards stats from DB", err)
+	}
+
+	return c.JSON(res)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errDefaultTemplateNotFound = errors.New("default template not found")
+
+// Template is a read-only system dashboard template seeded by migrations.
+type Template struct {
+	ID     int             `db:"id" json:"id"`
+	Name   string          `db:"name" json:"name"`
+	Graphs json.RawMessage `db:"graphs" json:"graphs"`
+}
+
+type TemplatesRes struct {
+	Templates []Template `json:"templates"`
+}
+
+// FromTemplateReq is an optional request of dashboard creation from a
+// template. Template name is used if name is empty.
+type FromTemplateReq struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) listTemplates(c *fiber.Ctx) error {
+	ts := []Template{}
+
+	err := s.readDB.Select("id", "name", "graphs").From("dashboard_template").
+		Order(goqu.C("id").Asc()).
+		Executor().ScanStructsContext(c.UserContext(), &ts)
+	if err != nil {
+		return internalError(c, "failed to get templates from DB", err)
+	}
+
+	return c.JSON(TemplatesRes{Templates: ts})
+}
+
+// defaultGraphs returns graphs of the default template.
+func (s *Server) defaultGraphs(ctx context.Context) (json.RawMessage, error) {
+	var graphs json.RawMessage
+
+	found, err := s.db.Select("graphs").From("dashboard_template").
+		Where(goqu.Ex{"id": s.config.DefaultTemplateID}).
+		Executor().ScanValContext(ctx, &graphs)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errDefaultTemplateNotFound
+	}
+
+	return graphs, nil
+}
+
+// createDashboardFromTemplate creates the user dashboard with graphs copied
+// from the template.
+func (s *Server) createDashboardFromTemplate(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	templateID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "invalid_template_id",
+			"failed to parse template ID")
+	}
+
+	var r FromTemplateReq
+
+	if len(c.Body()) > 0 {
+		err = json.Unmarshal(c.Body(), &r)
+		if err != nil {
+			return jsonErrorResponse(c, "failed to JSON unmarshal request",
+				err)
+		}
+	}
+
+	ctx := c.UserContext()
+
+	var t Template
+
+	found, err := s.db.Select("id", "name", "graphs").
+		From("dashboard_template").Where(goqu.Ex{"id": templateID}).
+		Executor().ScanStructContext(ctx, &t)
+	if err != nil {
+		return internalError(c, "failed to get template from DB", err)
+	}
+	if !found {
+		return errorResponse(c, http.StatusNotFound, "template_not_found",
+			"template not found")
+	}
+
+	d := Dashboard{Name: r.Name, Graphs: t.Graphs}
+	if d.Name == "" {
+		d.Name = t.Name
+	}
+
+	d.Name, err = validateName(d.Name)
+	if err != nil {
+		return nameErrorResponse(c, err)
+	}
+
+	errs, err := s.validateDashboard(ctx, userID, d)
+	if err != nil {
+		return internalError(c, "failed to validate dashboard", err)
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	id, err := s.insertDashboard(ctx, userID, d)
+	if err != nil {
+		return internalError(c, "failed to insert dashboard to db", err)
+	}
+
+	return c.JSON(AddDashboardsRes{ID: id})
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/core"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const (
+	defaultTestQueryRange  = time.Hour
+	defaultTestQueryPeriod = "300"
+	defaultTestQueryFilter = "average"
+
+	// maxTestQueryDatapoints is max number of the latest datapoints returned
+	// as a sample.
+	maxTestQueryDatapoints = 100
+)
+
+// TestQueryReq is a graph query test request. Zero from and to default to the
+// last hour, empty period and filter default to 5 minutes average.
+type TestQueryReq struct {
+	Graph  Graph  `json:"graph"`
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	Period string `json:"period"`
+	Filter string `json:"filter"`
+}
+
+type TestQueryRes struct {
+	Datapoints []core.Datapoint `json:"datapoints"`
+	Total      int              `json:"total"`
+	Message    string           `json:"message,omitempty"`
+}
+
+// graphMetricQuery translates the graph to CES metric data query.
+func graphMetricQuery(r TestQueryReq) core.MetricDataQuery {
+	return core.MetricDataQuery{
+		Namespace:  r.Graph.Namespace,
+		MetricName: r.Graph.MetricName,
+		Dimensions: r.Graph.Dimensions,
+		From:       r.From,
+		To:         r.To,
+		Period:     r.Period,
+		Filter:     r.Filter,
+	}
+}
+
+// validateTestQuery sets defaults of the request and validates it.
+func validateTestQuery(r *TestQueryReq) []ValidationError {
+	if r.To == 0 {
+		r.To = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if r.From == 0 {
+		r.From = r.To - int64(defaultTestQueryRange/time.Millisecond)
+	}
+	if r.Period == "" {
+		r.Period = defaultTestQueryPeriod
+	}
+	if r.Filter == "" {
+		r.Filter = defaultTestQueryFilter
+	}
+
+	var errs []ValidationError
+
+	if !graphTypes[r.Graph.Type] {
+		errs = append(errs, ValidationError{Field: "graph.type",
+			Message: fmt.Sprintf("unsupported graph type %q", r.Graph.Type)})
+	}
+
+	errs = append(errs, validateBatchQuery(BatchQueryReq{
+		Metrics: []BatchQueryMetric{{
+			Namespace:  r.Graph.Namespace,
+			MetricName: r.Graph.MetricName,
+			Dimensions: r.Graph.Dimensions,
+		}},
+		From:   r.From,
+		To:     r.To,
+		Period: r.Period,
+		Filter: r.Filter,
+	})...)
+
+	if len(r.Graph.Dimensions) > maxDimensions {
+		errs = append(errs, ValidationError{Field: "graph.dimensions",
+			Message: fmt.Sprintf("must contain at most %d dimensions",
+				maxDimensions)})
+	}
+
+	// Metric errors of the batch query validation are fields of the graph.
+	for i, e := range errs {
+		if strings.HasPrefix(e.Field, "metrics[0].") {
+			errs[i].Field = "graph" + strings.TrimPrefix(e.Field, "metrics[0]")
+		}
+	}
+
+	return errs
+}
+
+// cesTestQuery runs CES query of a single graph, so the user can check it
+// returns data before saving the graph to a dashboard.
+func (s *Server) cesTestQuery(c *fiber.Ctx) error {
+	var r TestQueryReq
+
+	err := json.Unmarshal(c.Body(), &r)
+	if err != nil {
+		return jsonErrorResponse(c, "failed to JSON unmarshal test query", err)
+	}
+
+	if errs := validateTestQuery(&r); len(errs) > 0 {
+		return errorDetailsResponse(c, http.StatusBadRequest,
+			"invalid_test_query", "test query is invalid", errs)
+	}
+
+	md, err := s.ces.GetMetricData(c.UserContext(), graphMetricQuery(r))
+	if err != nil {
+		var cesErr *core.CESError
+		if !errors.As(err, &cesErr) {
+			return cesRequestError(c, err)
+		}
+		details := CESErrorDetails{UpstreamStatus: cesErr.StatusCode}
+		switch cesErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return errorDetailsResponse(c, http.StatusForbidden,
+				"ces_forbidden", "CES access denied", details)
+		case http.StatusNotFound:
+			return errorDetailsResponse(c, http.StatusNotFound,
+				"ces_metric_not_found", "no such CES metric", details)
+		case http.StatusBadRequest:
+			return errorDetailsResponse(c, http.StatusUnprocessableEntity,
+				"ces_bad_query", "CES rejected the query: check period,"+
+					" filter and dimensions", details)
+		}
+		logger.Warnf("[ces test query] failed to get metric data: %v", err)
+		return errorDetailsResponse(c, http.StatusBadGateway,
+			codeCESUnavailable, "failed to get CES metric data", details)
+	}
+
+	res := TestQueryRes{
+		Datapoints: md.Datapoints,
+		Total:      len(md.Datapoints),
+	}
+
+	if len(res.Datapoints) > maxTestQueryDatapoints {
+		res.Datapoints = res.Datapoints[len(res.Datapoints)-
+			maxTestQueryDatapoints:]
+	}
+
+	if res.Datapoints == nil {
+		res.Datapoints = []core.Datapoint{}
+	}
+
+	if res.Total == 0 {
+		res.Message = "no datapoints in the time range: check metric name" +
+			" and dimensions"
+	}
+
+	return c.JSON(res)
+}
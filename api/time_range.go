@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epochSecondsLimit separates epoch seconds from epoch milliseconds: it's
+// year 5138 in seconds and year 1973 in milliseconds.
+const epochSecondsLimit = 1e11
+
+// parseTimeParam parses time as RFC3339 or epoch seconds or milliseconds and
+// returns it as epoch milliseconds CES expects.
+func parseTimeParam(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n <= 0 {
+			return 0, errors.New("must be positive")
+		}
+		if n < epochSecondsLimit {
+			n *= 1000
+		}
+		return n, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, errors.New("must be RFC3339 time or epoch seconds or" +
+			" milliseconds")
+	}
+
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// normalizeTimeRange parses from and to params and checks that from is
+// before to and the range doesn't exceed maxRange. Zero maxRange means no
+// limit.
+func normalizeTimeRange(from, to string, maxRange time.Duration) (int64,
+	int64, []ValidationError) {
+
+	var errs []ValidationError
+
+	f, err := parseTimeParam(from)
+	if err != nil {
+		errs = append(errs, ValidationError{Field: "from",
+			Message: err.Error()})
+	}
+
+	t, err := parseTimeParam(to)
+	if err != nil {
+		errs = append(errs, ValidationError{Field: "to",
+			Message: err.Error()})
+	}
+
+	if len(errs) > 0 {
+		return f, t, errs
+	}
+
+	if f >= t {
+		errs = append(errs, ValidationError{Field: "to",
+			Message: "must be greater than from"})
+	} else if maxRange > 0 && t-f > int64(maxRange/time.Millisecond) {
+		errs = append(errs, ValidationError{Field: "to",
+			Message: fmt.Sprintf("time range must not exceed %s", maxRange)})
+	}
+
+	return f, t, errs
+}
+
+// normalizeQueryTimeRange rewrites from and to params of CES query to epoch
+// milliseconds. Query without time range is left untouched.
+func normalizeQueryTimeRange(q url.Values,
+	maxRange time.Duration) []ValidationError {
+
+	if q.Get("from") == "" && q.Get("to") == "" {
+		return nil
+	}
+
+	from, to, errs := normalizeTimeRange(q.Get("from"), q.Get("to"),
+		maxRange)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	q.Set("from", strconv.FormatInt(from, 10))
+	q.Set("to", strconv.FormatInt(to, 10))
+
+	return nil
+}
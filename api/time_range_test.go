@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseTimeParam(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{name: "RFC3339", in: "2021-01-02T03:04:05Z", want: 1609556645000},
+		{name: "RFC3339 offset", in: "2021-01-02T06:04:05+03:00",
+			want: 1609556645000},
+		{name: "RFC3339 fraction", in: "2021-01-02T03:04:05.123Z",
+			want: 1609556645123},
+		{name: "epoch seconds", in: "1609556645", want: 1609556645000},
+		{name: "epoch milliseconds", in: "1609556645123",
+			want: 1609556645123},
+		{name: "trimmed", in: " 1609556645 ", want: 1609556645000},
+		{name: "seconds below limit", in: "99999999999",
+			want: 99999999999000},
+		{name: "milliseconds at limit", in: "100000000000",
+			want: 100000000000},
+		{name: "one second", in: "1", want: 1000},
+		{name: "zero", in: "0", wantErr: true},
+		{name: "negative", in: "-1609556645", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+		{name: "garbage", in: "yesterday", wantErr: true},
+		{name: "date only", in: "2021-01-02", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTimeParam(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseTimeParam(%q) = %d, want error", tc.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimeParam(%q) error: %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Errorf("parseTimeParam(%q) = %d, want %d", tc.in, got,
+					tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTimeRange(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		from, to   string
+		maxRange   time.Duration
+		wantFrom   int64
+		wantTo     int64
+		wantFields []string
+	}{
+		{name: "valid", from: "1609556645", to: "1609560245",
+			wantFrom: 1609556645000, wantTo: 1609560245000},
+		{name: "mixed formats", from: "2021-01-02T03:04:05Z",
+			to: "1609560245000", wantFrom: 1609556645000,
+			wantTo: 1609560245000},
+		{name: "equal", from: "1609556645", to: "1609556645",
+			wantFields: []string{"to"}},
+		{name: "reversed", from: "1609560245", to: "1609556645",
+			wantFields: []string{"to"}},
+		{name: "within max range", from: "1609556645", to: "1609560245",
+			maxRange: time.Hour, wantFrom: 1609556645000,
+			wantTo: 1609560245000},
+		{name: "exceeds max range", from: "1609556645", to: "1609560246",
+			maxRange: time.Hour, wantFields: []string{"to"}},
+		{name: "both invalid", from: "0", to: "x",
+			wantFields: []string{"from", "to"}},
+		{name: "from invalid", from: "-5", to: "1609560245",
+			wantFields: []string{"from"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, to, errs := normalizeTimeRange(tc.from, tc.to, tc.maxRange)
+
+			if len(errs) != len(tc.wantFields) {
+				t.Fatalf("got errors %v, want errors of fields %v", errs,
+					tc.wantFields)
+			}
+			for i, e := range errs {
+				if e.Field != tc.wantFields[i] {
+					t.Errorf("got error of field %q, want %q", e.Field,
+						tc.wantFields[i])
+				}
+			}
+
+			if len(errs) == 0 && (f != tc.wantFrom || to != tc.wantTo) {
+				t.Errorf("got range %d-%d, want %d-%d", f, to, tc.wantFrom,
+					tc.wantTo)
+			}
+		})
+	}
+}
+
+func TestNormalizeQueryTimeRange(t *testing.T) {
+	q := url.Values{"namespace": {"SYS.ECS"}}
+	if errs := normalizeQueryTimeRange(q, time.Hour); errs != nil {
+		t.Fatalf("query without range got errors %v", errs)
+	}
+	if q.Encode() != "namespace=SYS.ECS" {
+		t.Errorf("query without range is modified: %s", q.Encode())
+	}
+
+	q = url.Values{"from": {"2021-01-02T03:04:05Z"}, "to": {"1609560245"}}
+	if errs := normalizeQueryTimeRange(q, 0); errs != nil {
+		t.Fatalf("got errors %v", errs)
+	}
+	if q.Get("from") != "1609556645000" || q.Get("to") != "1609560245000" {
+		t.Errorf("got from=%s to=%s, want epoch milliseconds", q.Get("from"),
+			q.Get("to"))
+	}
+
+	q = url.Values{"to": {"1609560245"}}
+	if errs := normalizeQueryTimeRange(q, 0); len(errs) != 1 ||
+		errs[0].Field != "from" {
+		t.Errorf("missing from got errors %v, want from error", errs)
+	}
+}
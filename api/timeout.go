@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// timeout sets the deadline to the handlers user context and responds with
+// 504 if the deadline is exceeded. It's distinct from the server ReadTimeout
+// which limits only reading of the request.
+func timeout(d time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), d)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return errorResponse(c, http.StatusGatewayTimeout, "timeout",
+				"request processing timed out")
+		}
+
+		return err
+	}
+}
+
+// detachedContext keeps values of the parent, but is not cancelled with it.
+type detachedContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// detach returns context with the deadline of the parent, which is not
+// cancelled with the parent. It's used for upstream responses streamed after
+// the handler returns.
+func detach(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := detachedContext{Context: context.Background(), parent: parent}
+	if deadline, ok := parent.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cancelCloser cancels the context on close of the reader.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cc cancelCloser) Close() error {
+	err := cc.ReadCloser.Close()
+	cc.cancel()
+	return err
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/dimuls/sberhack-backend/tracing"
+)
+
+// traceRequests starts span of every request. Span context is propagated
+// from the request headers and passed to handlers with user context.
+func traceRequests(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(),
+		propagation.HeaderCarrier(http.Header(c.GetReqHeaders())))
+
+	ctx, span := tracing.Start(ctx, "HTTP "+c.Method(),
+		attribute.String("http.method", c.Method()),
+		attribute.String("http.target", c.Path()),
+		attribute.String("http.client_ip", c.IP()))
+	defer span.End()
+
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		} else {
+			status = http.StatusInternalServerError
+		}
+		span.RecordError(err)
+	}
+
+	span.SetName("HTTP " + c.Method() + " " + c.Route().Path)
+	span.SetAttributes(attribute.String("http.route", c.Route().Path),
+		attribute.Int("http.status_code", status))
+
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+
+	return err
+}
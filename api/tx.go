@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/lib/pq"
+)
+
+const (
+	// txMaxAttempts is max number of attempts of transaction failed with
+	// retriable error.
+	txMaxAttempts = 3
+
+	// txRetryBackoff is a delay before the second attempt. It's doubled for
+	// every next one.
+	txRetryBackoff = 20 * time.Millisecond
+)
+
+// Postgres error codes of transaction failures which are resolved by retry.
+const (
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+// isRetriableTxError reports whether the transaction failed due to
+// concurrent transactions and may succeed on retry.
+func isRetriableTxError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqSerializationFailure ||
+			pqErr.Code == pqDeadlockDetected
+	}
+	return false
+}
+
+// withTx runs fn in a transaction of the db and commits it if fn succeeds or
+// rolls it back otherwise. Transactions failed with serialization failure or
+// deadlock are retried with backoff, so fn may be called several times and
+// must not have side effects outside the tx.
+func withTx(ctx context.Context, db *goqu.Database,
+	fn func(tx *goqu.TxDatabase) error) error {
+
+	backoff := txRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Wrap(func() error {
+			return fn(tx)
+		})
+		if err == nil || attempt == txMaxAttempts ||
+			!isRetriableTxError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
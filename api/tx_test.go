@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/lib/pq"
+)
+
+// fakeTxDB is a database/sql driver which fails statements and commits with
+// scripted errors. It counts transactions outcomes.
+type fakeTxDB struct {
+	mx sync.Mutex
+
+	execErrs   []error
+	commitErrs []error
+
+	begins    int
+	commits   int
+	rollbacks int
+}
+
+// popErr pops the next scripted error.
+func popErr(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (db *fakeTxDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeTxConn{db: db}, nil
+}
+
+func (db *fakeTxDB) Driver() driver.Driver {
+	return nil
+}
+
+type fakeTxConn struct {
+	db *fakeTxDB
+}
+
+func (c fakeTxConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare isn't supported")
+}
+
+func (c fakeTxConn) Close() error {
+	return nil
+}
+
+func (c fakeTxConn) Begin() (driver.Tx, error) {
+	c.db.mx.Lock()
+	defer c.db.mx.Unlock()
+	c.db.begins++
+	return fakeTx(c), nil
+}
+
+func (c fakeTxConn) ExecContext(context.Context, string,
+	[]driver.NamedValue) (driver.Result, error) {
+
+	c.db.mx.Lock()
+	defer c.db.mx.Unlock()
+
+	if err := popErr(&c.db.execErrs); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeTx struct {
+	db *fakeTxDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.mx.Lock()
+	defer tx.db.mx.Unlock()
+
+	if err := popErr(&tx.db.commitErrs); err != nil {
+		return err
+	}
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.mx.Lock()
+	defer tx.db.mx.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+func newFakeTxDB(execErrs, commitErrs []error) (*fakeTxDB, *goqu.Database) {
+	fdb := &fakeTxDB{execErrs: execErrs, commitErrs: commitErrs}
+	return fdb, goqu.New("postgres", sql.OpenDB(fdb))
+}
+
+func updateInTx(calls *int) func(tx *goqu.TxDatabase) error {
+	return func(tx *goqu.TxDatabase) error {
+		*calls++
+		_, err := tx.ExecContext(context.Background(),
+			"update dashboard set name = 'x'")
+		return err
+	}
+}
+
+var (
+	errSerialization = &pq.Error{Code: pqSerializationFailure}
+	errDeadlock      = &pq.Error{Code: pqDeadlockDetected}
+)
+
+func TestWithTxRetriesSerializationFailure(t *testing.T) {
+	fdb, db := newFakeTxDB([]error{errSerialization, errDeadlock}, nil)
+
+	var calls int
+
+	err := withTx(context.Background(), db, updateInTx(&calls))
+	if err != nil {
+		t.Fatalf("withTx error: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+	if fdb.begins != 3 || fdb.rollbacks != 2 || fdb.commits != 1 {
+		t.Errorf("got %d begins, %d rollbacks, %d commits, want 3, 2, 1",
+			fdb.begins, fdb.rollbacks, fdb.commits)
+	}
+}
+
+func TestWithTxRetriesCommitSerializationFailure(t *testing.T) {
+	fdb, db := newFakeTxDB(nil, []error{errSerialization})
+
+	var calls int
+
+	err := withTx(context.Background(), db, updateInTx(&calls))
+	if err != nil {
+		t.Fatalf("withTx error: %v", err)
+	}
+
+	if calls != 2 || fdb.commits != 1 {
+		t.Errorf("got %d calls and %d commits, want 2 and 1", calls,
+			fdb.commits)
+	}
+}
+
+func TestWithTxGivesUp(t *testing.T) {
+	fdb, db := newFakeTxDB([]error{errSerialization, errSerialization,
+		errSerialization, errSerialization}, nil)
+
+	var calls int
+
+	err := withTx(context.Background(), db, updateInTx(&calls))
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != pqSerializationFailure {
+		t.Fatalf("got error %v, want serialization failure", err)
+	}
+
+	if calls != txMaxAttempts {
+		t.Errorf("fn called %d times, want %d", calls, txMaxAttempts)
+	}
+	if fdb.rollbacks != txMaxAttempts || fdb.commits != 0 {
+		t.Errorf("got %d rollbacks and %d commits, want %d and 0",
+			fdb.rollbacks, fdb.commits, txMaxAttempts)
+	}
+}
+
+func TestWithTxDoesntRetryOtherErrors(t *testing.T) {
+	uniqueViolation := &pq.Error{Code: "23505"}
+
+	fdb, db := newFakeTxDB([]error{uniqueViolation}, nil)
+
+	var calls int
+
+	err := withTx(context.Background(), db, updateInTx(&calls))
+	if !errors.Is(err, uniqueViolation) {
+		t.Fatalf("got error %v, want unique violation", err)
+	}
+
+	if calls != 1 || fdb.rollbacks != 1 {
+		t.Errorf("got %d calls and %d rollbacks, want 1 and 1", calls,
+			fdb.rollbacks)
+	}
+}
+
+func TestWithTxStopsRetryOnCancel(t *testing.T) {
+	_, db := newFakeTxDB([]error{errSerialization, errSerialization}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls int
+
+	err := withTx(ctx, db, func(tx *goqu.TxDatabase) error {
+		calls++
+		_, err := tx.ExecContext(context.Background(),
+			"update dashboard set name = 'x'")
+		cancel()
+		return err
+	})
+	if !errors.Is(err, errSerialization) {
+		t.Fatalf("got error %v, want serialization failure", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times after cancel, want 1", calls)
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	codeValidationFailed = "validation_failed"
+	codeInvalidName      = "invalid_name"
+
+	maxNameLen        = 200
+	maxDescriptionLen = 4000
+)
+
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	// Offset is a byte offset of the error in the request body if known.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// validationErrors is an error wrapping list of validation errors.
+type validationErrors []ValidationError
+
+func (errs validationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type ValidRes struct {
+	Valid bool `json:"valid"`
+}
+
+// validateName returns dashboard name with trimmed whitespace. Empty, too
+// long, not UTF-8 names and names with control characters are rejected.
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	switch {
+	case name == "":
+		return "", errors.New("name must not be empty")
+	case !utf8.ValidString(name):
+		return "", errors.New("name must be valid UTF-8")
+	case utf8.RuneCountInString(name) > maxNameLen:
+		return "", fmt.Errorf("name must be at most %d characters long",
+			maxNameLen)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", errors.New("name must not contain control characters")
+		}
+	}
+
+	return name, nil
+}
+
+func nameErrorResponse(c *fiber.Ctx, err error) error {
+	return errorResponse(c, http.StatusBadRequest, codeInvalidName,
+		err.Error())
+}
+
+// selecter is the query builder of goqu.Database and goqu.TxDatabase, so
+// checks can run both standalone and within a transaction.
+type selecter interface {
+	Select(cols ...interface{}) *goqu.SelectDataset
+}
+
+// checkNameUnique checks that user has no other dashboard with the name.
+func checkNameUnique(ctx context.Context, db selecter, userID, name string,
+	dashboardID int) ([]ValidationError, error) {
+
+	var id int
+
+	found, err := db.Select("id").From("dashboard").Where(
+		goqu.Ex{"user_id": userID, "name": name},
+		goqu.C("id").Neq(dashboardID)).Executor().ScanValContext(ctx, &id)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return []ValidationError{{Field: "name",
+			Message: "dashboard with such name already exists"}}, nil
+	}
+
+	return nil, nil
+}
+
+// validateDashboard runs all dashboard validations. Same validations are used
+// by create, update and dry run.
+func (s *Server) validateDashboard(ctx context.Context, userID string,
+	d Dashboard) ([]ValidationError, error) {
+
+	errs := validateGraphs(d.Graphs, d.GraphsVersion, s.config.MaxGraphs)
+
+	if !utf8.ValidString(d.Description) {
+		errs = append(errs, ValidationError{Field: "description",
+			Message: "must be valid UTF-8"})
+	} else if utf8.RuneCountInString(d.Description) > maxDescriptionLen {
+		errs = append(errs, ValidationError{Field: "description",
+			Message: fmt.Sprintf("must be at most %d characters long",
+				maxDescriptionLen)})
+	}
+
+	if len(errs) == 0 {
+		nameErrs, err := checkNameUnique(ctx, s.db, userID, d.Name,
+			d.ID)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, nameErrs...)
+	}
+
+	return errs, nil
+}
+
+// jsonTypeName returns JSON type name of the Go type.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return t.String()
+}
+
+// jsonErrors returns validation errors of the body JSON unmarshal error. They
+// point to the offending field and byte offset when it's possible.
+func jsonErrors(body []byte, err error) []ValidationError {
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		line, col := 1, 1
+		for _, b := range body[:min64(se.Offset, int64(len(body)))] {
+			if b == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+		return []ValidationError{{Field: "body", Offset: se.Offset,
+			Message: fmt.Sprintf("invalid JSON at line %d, column %d: %v",
+				line, col, se)}}
+	}
+
+	var ute *json.UnmarshalTypeError
+	if errors.As(err, &ute) {
+		field := ute.Field
+		if field == "" {
+			field = "body"
+		}
+		return []ValidationError{{Field: field, Offset: ute.Offset,
+			Message: fmt.Sprintf("must be %s, got %s",
+				jsonTypeName(ute.Type), ute.Value)}}
+	}
+
+	return []ValidationError{{Field: "body", Message: err.Error()}}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// jsonErrorResponse responds to the request body JSON unmarshal error.
+func jsonErrorResponse(c *fiber.Ctx, msg string, err error) error {
+	return errorDetailsResponse(c, http.StatusBadRequest, codeInvalidJSON,
+		msg, jsonErrors(c.Body(), err))
+}
+
+func validationErrorResponse(c *fiber.Ctx, errs []ValidationError) error {
+	return errorDetailsResponse(c, http.StatusUnprocessableEntity,
+		codeValidationFailed, "dashboard is invalid", errs)
+}
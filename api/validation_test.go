@@ -0,0 +1,48 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", in: "CPU", want: "CPU"},
+		{name: "trimmed", in: " \t CPU usage \n", want: "CPU usage"},
+		{name: "inner spaces kept", in: "a  b", want: "a  b"},
+		{name: "unicode", in: "Загрузка ЦПУ", want: "Загрузка ЦПУ"},
+		{name: "empty", in: "", wantErr: true},
+		{name: "only spaces", in: "  \t\n ", wantErr: true},
+		{name: "invalid UTF-8", in: "cpu\xff", wantErr: true},
+		{name: "max runes", in: strings.Repeat("я", maxNameLen),
+			want: strings.Repeat("я", maxNameLen)},
+		{name: "too many runes", in: strings.Repeat("я", maxNameLen+1),
+			wantErr: true},
+		{name: "limit after trim", in: " " + strings.Repeat("a", maxNameLen) +
+			" ", want: strings.Repeat("a", maxNameLen)},
+		{name: "control character", in: "cpu\x00usage", wantErr: true},
+		{name: "inner newline", in: "cpu\nusage", wantErr: true},
+		{name: "C1 control character", in: "cpu\u0085usage", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := validateName(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("validateName(%q) = %q, want error", tc.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateName(%q) error: %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Errorf("validateName(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type VersionRes struct {
+	Commit        string    `json:"commit"`
+	BuildTime     string    `json:"build_time"`
+	StartTime     time.Time `json:"start_time"`
+	Uptime        string    `json:"uptime"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+}
+
+func (s *Server) version(c *fiber.Ctx) error {
+	uptime := time.Since(s.config.StartTime)
+
+	return c.JSON(VersionRes{
+		Commit:        s.config.Commit,
+		BuildTime:     s.config.BuildTime,
+		StartTime:     s.config.StartTime,
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: int64(uptime / time.Second),
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+const (
+	defaultRecentLimit = 10
+	maxRecentLimit     = 50
+	recordViewTimeout  = 5 * time.Second
+)
+
+type RecentDashboard struct {
+	ID        int       `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	ViewedAt  time.Time `db:"viewed_at" json:"viewed_at"`
+}
+
+type RecentDashboardsRes struct {
+	Dashboards []RecentDashboard `json:"dashboards"`
+}
+
+// recordView asynchronously upserts the user dashboard view time. It's
+// best-effort: failure is only logged.
+func (s *Server) recordView(userID string, dashboardID int) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			recordViewTimeout)
+		defer cancel()
+
+		_, err := s.db.Insert("dashboard_view").Rows(goqu.Record{
+			"user_id":      userID,
+			"dashboard_id": dashboardID,
+			"viewed_at":    goqu.L("now()"),
+		}).OnConflict(goqu.DoUpdate("user_id, dashboard_id", goqu.Record{
+			"viewed_at": goqu.L("now()"),
+		})).Executor().ExecContext(ctx)
+		if err != nil {
+			logger.Warnf("[views] failed to record dashboard view:"+
+				" user_id=%s dashboard_id=%d: %v", userID, dashboardID, err)
+		}
+	}()
+}
+
+func (s *Server) recentDashboards(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return internalError(c, "expected local userID string", nil)
+	}
+
+	limit := defaultRecentLimit
+
+	if l := c.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxRecentLimit {
+			return errorResponse(c, http.StatusBadRequest, "invalid_limit",
+				"limit must be integer from 1 to "+
+					strconv.Itoa(maxRecentLimit))
+		}
+	}
+
+	ds := []RecentDashboard{}
+
+	err := s.db.Select(goqu.I("d.id"), goqu.I("d.name"),
+		goqu.I("d.updated_at"), goqu.I("v.viewed_at")).
+		From(goqu.T("dashboard_view").As("v")).
+		Join(goqu.T("dashboard").As("d"), goqu.On(goqu.Ex{
+			"d.id":      goqu.I("v.dashboard_id"),
+			"d.user_id": goqu.I("v.user_id"),
+		})).
+		Where(goqu.Ex{"v.user_id": userID}).
+		Order(goqu.I("v.viewed_at").Desc()).
+		Limit(uint(limit)).Executor().ScanStructsContext(c.UserContext(), &ds)
+	if err != nil {
+		return internalError(c, "failed to get recent dashboards from DB", err)
+	}
+
+	return c.JSON(RecentDashboardsRes{Dashboards: ds})
+}
@@ -0,0 +1,413 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/dimuls/sberhack-backend/tracing"
+)
+
+type Dimension struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Metric struct {
+	Namespace  string      `json:"namespace"`
+	MetricName string      `json:"metric_name"`
+	Unit       string      `json:"unit"`
+	Dimensions []Dimension `json:"dimensions"`
+}
+
+// ListMetricsParams are CES list metrics query parameters. Zero values are
+// omitted.
+type ListMetricsParams struct {
+	Namespace  string
+	MetricName string
+	Dimensions []Dimension
+	Limit      int
+	Start      string
+}
+
+type ListMetricsRes struct {
+	Metrics  []Metric `json:"metrics"`
+	MetaData struct {
+		Count  int    `json:"count"`
+		Marker string `json:"marker"`
+		Total  int    `json:"total"`
+	} `json:"meta_data"`
+}
+
+type Datapoint struct {
+	Average   *float64 `json:"average,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Sum       *float64 `json:"sum,omitempty"`
+	Variance  *float64 `json:"variance,omitempty"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// Value returns datapoint value of the filter.
+func (d Datapoint) Value(filter string) *float64 {
+	switch filter {
+	case "average":
+		return d.Average
+	case "max":
+		return d.Max
+	case "min":
+		return d.Min
+	case "sum":
+		return d.Sum
+	case "variance":
+		return d.Variance
+	}
+	return nil
+}
+
+// MetricDataQuery is CES metric data query of single metric.
+type MetricDataQuery struct {
+	Namespace  string
+	MetricName string
+	Dimensions []Dimension
+	From       int64
+	To         int64
+	Period     string
+	Filter     string
+}
+
+type MetricDataRes struct {
+	MetricName string      `json:"metric_name"`
+	Datapoints []Datapoint `json:"datapoints"`
+}
+
+// BatchQueryReq is CES batch-query-metric-data request.
+type BatchQueryReq struct {
+	Metrics []BatchQueryMetric `json:"metrics"`
+	From    int64              `json:"from"`
+	To      int64              `json:"to"`
+	Period  string             `json:"period"`
+	Filter  string             `json:"filter"`
+}
+
+type BatchQueryMetric struct {
+	Namespace  string      `json:"namespace"`
+	MetricName string      `json:"metric_name"`
+	Dimensions []Dimension `json:"dimensions"`
+}
+
+type BatchQueryResMetric struct {
+	BatchQueryMetric
+	Unit       string      `json:"unit"`
+	Datapoints []Datapoint `json:"datapoints"`
+}
+
+// BatchQueryRes is CES batch-query-metric-data response.
+type BatchQueryRes struct {
+	Metrics []BatchQueryResMetric `json:"metrics"`
+}
+
+// CESError is returned when CES responds with unexpected status.
+type CESError struct {
+	StatusCode int
+}
+
+func (e *CESError) Error() string {
+	return fmt.Sprintf("CES responded with status %d", e.StatusCode)
+}
+
+// ErrCESCircuitOpen is returned when CES requests are rejected by the
+// circuit breaker after upstream failures.
+var ErrCESCircuitOpen = errors.New("CES circuit breaker is open")
+
+// CESClient does signed requests to SberCloud CES API.
+type CESClient struct {
+	URL       string
+	ProjectID string
+	Signer    Signer
+	Client    *http.Client
+
+	// Breaker fast-fails requests while CES is failing if it is set.
+	// Transport errors and 5xx responses are counted as failures.
+	Breaker *gobreaker.TwoStepCircuitBreaker
+
+	// OnRequest is called with URL of every request if it is set.
+	OnRequest func(url string)
+
+	// QuerySignPaths are paths which requests are signed with query
+	// parameters regardless of the signer mode, for endpoints which don't
+	// accept header signature.
+	QuerySignPaths []*regexp.Regexp
+}
+
+// signMode returns sign mode of the path requests.
+func (c *CESClient) signMode(path string) SignMode {
+	path = strings.TrimLeft(path, "/")
+	for _, r := range c.QuerySignPaths {
+		if r.MatchString(path) {
+			return SignQuery
+		}
+	}
+	return c.Signer.Mode
+}
+
+// URLOf returns URL of the CES API path relative to the base URL.
+func (c *CESClient) URLOf(path string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CES URL: %w", err)
+	}
+
+	if path != "" {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" +
+			strings.TrimLeft(path, "/")
+	}
+
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	return u, nil
+}
+
+// Do does signed request to the CES API path. The path is relative to the
+// base URL and must be unescaped. Header is added to the request if it is not
+// nil. Caller must close the response body.
+func (c *CESClient) Do(ctx context.Context, method, path string,
+	query url.Values, header http.Header, body io.Reader) (*http.Response,
+	error) {
+
+	u, err := c.URLOf(path, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query isn't recorded since it may contain sensitive dimensions.
+	ctx, span := tracing.Start(ctx, "ces.request",
+		attribute.String("http.method", method),
+		attribute.String("http.url", u.Scheme+"://"+u.Host+u.Path))
+
+	res, err := c.do(ctx, method, u, c.signMode(path), header, body)
+	if res != nil {
+		span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	}
+	tracing.End(span, err)
+
+	return res, err
+}
+
+func (c *CESClient) do(ctx context.Context, method string, u *url.URL,
+	mode SignMode, header http.Header, body io.Reader) (*http.Response,
+	error) {
+
+	if c.OnRequest != nil {
+		c.OnRequest(u.String())
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	for k, vs := range header {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+
+	r.Header.Add("x-stage", "RELEASE")
+
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	err = c.Signer.SignWithMode(r, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign http request: %w", err)
+	}
+
+	if c.Breaker == nil {
+		return c.Client.Do(r)
+	}
+
+	done, err := c.Breaker.Allow()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCESCircuitOpen, err)
+	}
+
+	res, err := c.Client.Do(r)
+
+	// Requests cancelled by the caller say nothing about CES health.
+	done(err == nil && res.StatusCode < 500 ||
+		errors.Is(err, context.Canceled))
+
+	return res, err
+}
+
+// doJSON does request and JSON decodes response to res. Response with status
+// other than 200 results to CESError.
+func (c *CESClient) doJSON(ctx context.Context, method, path string,
+	query url.Values, req, res interface{}) error {
+
+	var body io.Reader
+
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal CES request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	// Encoding is requested explicitly, so the transport doesn't decode
+	// response transparently and it's always decoded by DecodeBody.
+	r, err := c.Do(ctx, method, path, query, http.Header{
+		"Accept-Encoding": {"gzip, deflate"},
+	}, body)
+	if err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return &CESError{StatusCode: r.StatusCode}
+	}
+
+	rb, err := DecodeBody(r)
+	if err != nil {
+		return err
+	}
+
+	defer rb.Close()
+
+	err = json.NewDecoder(rb).Decode(res)
+	if err != nil {
+		return fmt.Errorf("failed to JSON decode CES response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *CESClient) projectPath(path string) string {
+	return c.ProjectID + "/" + path
+}
+
+func dimensionsQuery(q url.Values, ds []Dimension) {
+	for i, d := range ds {
+		q.Set("dim."+strconv.Itoa(i), d.Name+","+d.Value)
+	}
+}
+
+// ListMetrics lists one page of CES metrics.
+func (c *CESClient) ListMetrics(ctx context.Context, p ListMetricsParams) (
+	ListMetricsRes, error) {
+
+	q := url.Values{}
+	if p.Namespace != "" {
+		q.Set("namespace", p.Namespace)
+	}
+	if p.MetricName != "" {
+		q.Set("metric_name", p.MetricName)
+	}
+	dimensionsQuery(q, p.Dimensions)
+	if p.Limit > 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Start != "" {
+		q.Set("start", p.Start)
+	}
+
+	var res ListMetricsRes
+
+	err := c.doJSON(ctx, http.MethodGet, c.projectPath("metrics"), q, nil,
+		&res)
+
+	return res, err
+}
+
+// GetMetricData gets datapoints of single CES metric.
+func (c *CESClient) GetMetricData(ctx context.Context, mq MetricDataQuery) (
+	MetricDataRes, error) {
+
+	q := url.Values{}
+	q.Set("namespace", mq.Namespace)
+	q.Set("metric_name", mq.MetricName)
+	dimensionsQuery(q, mq.Dimensions)
+	q.Set("from", strconv.FormatInt(mq.From, 10))
+	q.Set("to", strconv.FormatInt(mq.To, 10))
+	q.Set("period", mq.Period)
+	q.Set("filter", mq.Filter)
+
+	var res MetricDataRes
+
+	err := c.doJSON(ctx, http.MethodGet, c.projectPath("metric-data"), q,
+		nil, &res)
+
+	return res, err
+}
+
+// BatchQueryMetricData gets datapoints of several CES metrics.
+func (c *CESClient) BatchQueryMetricData(ctx context.Context,
+	bqr BatchQueryReq) (BatchQueryRes, error) {
+
+	var res BatchQueryRes
+
+	err := c.doJSON(ctx, http.MethodPost,
+		c.projectPath("batch-query-metric-data"), nil, bqr, &res)
+
+	return res, err
+}
+
+// BatchQueryMetricDataRaw does batch query and returns raw CES response to
+// stream it. Caller must close the response body.
+func (c *CESClient) BatchQueryMetricDataRaw(ctx context.Context,
+	bqr BatchQueryReq) (*http.Response, error) {
+
+	b, err := json.Marshal(bqr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to JSON marshal CES request: %w", err)
+	}
+
+	return c.Do(ctx, http.MethodPost, c.projectPath("batch-query-metric-data"),
+		nil, nil, bytes.NewReader(b))
+}
+
+// DecodeBody returns response body decoded according to its
+// Content-Encoding. Closing returned body doesn't close the response body.
+func DecodeBody(res *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(
+		res.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return ioutil.NopCloser(res.Body), nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return r, nil
+	case "deflate":
+		r, err := zlib.NewReader(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create deflate reader: %w", err)
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q",
+			res.Header.Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// FanOut runs fn for every item by at most concurrency workers and returns
+// results and errors of the items in order. Items not started before ctx is
+// done aren't run and get ctx error. Zero concurrency means a worker per
+// item.
+func FanOut[T, R any](ctx context.Context, items []T, concurrency int,
+	fn func(ctx context.Context, item T) (R, error)) ([]R, []error) {
+
+	n := len(items)
+
+	results := make([]R, n)
+	errs := make([]error, n)
+
+	if concurrency <= 0 || concurrency > n {
+		concurrency = n
+	}
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i], errs[i] = fn(ctx, items[i])
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+
+	close(indexes)
+
+	wg.Wait()
+
+	return results, errs
+}
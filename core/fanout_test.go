@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// indexes returns items 0 to n-1.
+func indexes(n int) []int {
+	is := make([]int, n)
+	for i := range is {
+		is[i] = i
+	}
+	return is
+}
+
+func TestFanOutConcurrencyBound(t *testing.T) {
+	const (
+		n           = 50
+		concurrency = 4
+	)
+
+	var running, maxRunning int32
+
+	_, errs := FanOut(context.Background(), indexes(n), concurrency,
+		func(ctx context.Context, i int) (struct{}, error) {
+			r := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+					break
+				}
+			}
+
+			time.Sleep(time.Millisecond)
+			return struct{}{}, nil
+		})
+
+	if len(errs) != n {
+		t.Fatalf("got %d errors, want %d", len(errs), n)
+	}
+	if maxRunning > concurrency {
+		t.Errorf("got %d concurrent items, want at most %d", maxRunning,
+			concurrency)
+	}
+	if maxRunning < 2 {
+		t.Errorf("got %d concurrent items, want items run concurrently",
+			maxRunning)
+	}
+}
+
+func TestFanOutResultsInOrder(t *testing.T) {
+	const n = 20
+
+	results, errs := FanOut(context.Background(), indexes(n), 5,
+		func(ctx context.Context, i int) (int, error) {
+			// Later items finish first.
+			time.Sleep(time.Duration(n-i) * 100 * time.Microsecond)
+			if i%3 == 0 {
+				return 0, fmt.Errorf("item %d", i)
+			}
+			return i * i, nil
+		})
+
+	if len(results) != n {
+		t.Fatalf("got %d results, want %d", len(results), n)
+	}
+
+	for i := 0; i < n; i++ {
+		if i%3 == 0 {
+			if errs[i] == nil || errs[i].Error() != fmt.Sprintf("item %d", i) {
+				t.Errorf("got error %v of item %d, want its own error",
+					errs[i], i)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("got error %v of item %d, want nil", errs[i], i)
+		}
+		if results[i] != i*i {
+			t.Errorf("got result %d of item %d, want %d", results[i], i, i*i)
+		}
+	}
+}
+
+func TestFanOutCancel(t *testing.T) {
+	const n = 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+
+	_, errs := FanOut(ctx, indexes(n), 1,
+		func(ctx context.Context, i int) (struct{}, error) {
+			atomic.AddInt32(&calls, 1)
+			if i == 2 {
+				cancel()
+				return struct{}{}, ctx.Err()
+			}
+			return struct{}{}, nil
+		})
+
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		if errs[i] != nil {
+			t.Errorf("got error %v of item %d started before cancel", errs[i],
+				i)
+		}
+	}
+
+	for i := 2; i < n; i++ {
+		if !errors.Is(errs[i], context.Canceled) {
+			t.Errorf("got error %v of item %d, want context.Canceled",
+				errs[i], i)
+		}
+	}
+}
+
+func TestFanOutZeroConcurrency(t *testing.T) {
+	var calls int32
+
+	_, errs := FanOut(context.Background(), indexes(3), 0,
+		func(ctx context.Context, i int) (struct{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return struct{}{}, nil
+		})
+
+	if len(errs) != 3 || calls != 3 {
+		t.Errorf("got %d errors and %d calls, want 3 and 3", len(errs), calls)
+	}
+
+	results, errs := FanOut(context.Background(), nil, 4,
+		func(ctx context.Context, i int) (struct{}, error) {
+			t.Errorf("fn called without items")
+			return struct{}{}, nil
+		})
+	if len(results) != 0 || len(errs) != 0 {
+		t.Errorf("got %d results and %d errors without items, want 0 and 0",
+			len(results), len(errs))
+	}
+}
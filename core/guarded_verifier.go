@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// GuardedVerifier protects underlying TokenVerifier from floods of invalid
+// tokens. Invalid token verdicts are cached for InvalidTTL, so repeated bad
+// tokens fail fast, and number of concurrent verifications is bounded.
+type GuardedVerifier struct {
+	verifier   TokenVerifier
+	invalidTTL time.Duration
+	sem        chan struct{}
+
+	mx      sync.Mutex
+	invalid map[[sha256.Size]byte]time.Time
+}
+
+// NewGuardedVerifier creates new GuardedVerifier. Zero invalidTTL disables
+// invalid tokens caching, zero maxConcurrent disables concurrency limit.
+func NewGuardedVerifier(v TokenVerifier, invalidTTL time.Duration,
+	maxConcurrent int) *GuardedVerifier {
+
+	gv := &GuardedVerifier{
+		verifier:   v,
+		invalidTTL: invalidTTL,
+		invalid:    map[[sha256.Size]byte]time.Time{},
+	}
+
+	if maxConcurrent > 0 {
+		gv.sem = make(chan struct{}, maxConcurrent)
+	}
+
+	return gv
+}
+
+// Verify returns ErrInvalidToken without underlying verifier call if the
+// token was found invalid recently. It waits for a free verification slot
+// and returns ctx error if ctx is done before: caller gave up, so it says
+// nothing about the token service health.
+func (v *GuardedVerifier) Verify(ctx context.Context, token string) (
+	string, error) {
+
+	// Tokens are kept hashed, so they aren't exposed in memory dumps.
+	key := sha256.Sum256([]byte(token))
+
+	if v.isInvalid(key) {
+		return "", ErrInvalidToken
+	}
+
+	if v.sem != nil {
+		select {
+		case v.sem <- struct{}{}:
+			defer func() { <-v.sem }()
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	userID, err := v.verifier.Verify(ctx, token)
+	if err == ErrInvalidToken {
+		v.setInvalid(key)
+	}
+
+	return userID, err
+}
+
+func (v *GuardedVerifier) isInvalid(key [sha256.Size]byte) bool {
+	if v.invalidTTL <= 0 {
+		return false
+	}
+
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	expiresAt, ok := v.invalid[key]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(expiresAt) {
+		delete(v.invalid, key)
+		return false
+	}
+
+	return true
+}
+
+func (v *GuardedVerifier) setInvalid(key [sha256.Size]byte) {
+	if v.invalidTTL <= 0 {
+		return
+	}
+
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	now := time.Now()
+
+	for k, expiresAt := range v.invalid {
+		if now.After(expiresAt) {
+			delete(v.invalid, k)
+		}
+	}
+
+	v.invalid[key] = now.Add(v.invalidTTL)
+}
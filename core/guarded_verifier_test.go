@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// funcVerifier is TokenVerifier calling the function.
+type funcVerifier func(ctx context.Context, token string) (string, error)
+
+func (f funcVerifier) Verify(ctx context.Context, token string) (string,
+	error) {
+	return f(ctx, token)
+}
+
+func TestGuardedVerifierCallerCancelWhileWaiting(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	gv := NewGuardedVerifier(funcVerifier(
+		func(ctx context.Context, token string) (string, error) {
+			close(started)
+			<-release
+			return "user", nil
+		}), 0, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := gv.Verify(context.Background(), "first")
+		done <- err
+	}()
+
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := gv.Verify(ctx, "second")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if errors.Is(err, ErrTokenServiceUnavailable) {
+		t.Errorf("caller cancellation is reported as service unavailable")
+	}
+
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Errorf("first verification error: %v", err)
+	}
+}
+
+func TestGuardedVerifierUpstreamUnavailable(t *testing.T) {
+	gv := NewGuardedVerifier(funcVerifier(
+		func(ctx context.Context, token string) (string, error) {
+			return "", ErrTokenServiceUnavailable
+		}), time.Minute, 1)
+
+	_, err := gv.Verify(context.Background(), "token")
+	if !errors.Is(err, ErrTokenServiceUnavailable) {
+		t.Errorf("got error %v, want ErrTokenServiceUnavailable", err)
+	}
+}
+
+func TestGuardedVerifierCachesInvalid(t *testing.T) {
+	var calls int32
+
+	gv := NewGuardedVerifier(funcVerifier(
+		func(ctx context.Context, token string) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			return "", ErrInvalidToken
+		}), time.Minute, 0)
+
+	for i := 0; i < 3; i++ {
+		_, err := gv.Verify(context.Background(), "bad")
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("got error %v, want ErrInvalidToken", err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("underlying verifier called %d times, want 1", calls)
+	}
+}
+
+func TestIAMVerifierErrors(t *testing.T) {
+	iam := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(HeaderXAuthToken) == "hang" {
+				<-r.Context().Done()
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+	defer iam.Close()
+
+	v := &IAMVerifier{URL: iam.URL, Client: iam.Client()}
+
+	_, err := v.Verify(context.Background(), "token")
+	if !errors.Is(err, ErrTokenServiceUnavailable) {
+		t.Errorf("got error %v on IAM failure, want"+
+			" ErrTokenServiceUnavailable", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+
+	_, err = v.Verify(ctx, "hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v on caller deadline, want"+
+			" context.DeadlineExceeded", err)
+	}
+	if errors.Is(err, ErrTokenServiceUnavailable) {
+		t.Errorf("caller deadline is reported as service unavailable")
+	}
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// maxTokenLen is max length of token passing local check. IAM PKI tokens
+// are several kilobytes long.
+const maxTokenLen = 32 * 1024
+
+// PrecheckVerifier rejects malformed and expired tokens locally and passes
+// only the rest to the underlying verifier. Local check never accepts a
+// token by itself: every accepted token is verified by the underlying
+// verifier.
+type PrecheckVerifier struct {
+	Verifier TokenVerifier
+
+	// Now returns current time. It's time.Now if nil.
+	Now func() time.Time
+}
+
+// Verify returns ErrInvalidToken if the token fails local check, otherwise
+// it returns result of the underlying verifier.
+func (v *PrecheckVerifier) Verify(ctx context.Context, token string) (
+	string, error) {
+
+	if !v.precheck(token) {
+		return "", ErrInvalidToken
+	}
+
+	return v.Verifier.Verify(ctx, token)
+}
+
+// precheck reports whether the token may be valid: it's of sane length,
+// consists of printable ASCII and isn't expired if it's a JWT.
+func (v *PrecheckVerifier) precheck(token string) bool {
+	if token == "" || len(token) > maxTokenLen {
+		return false
+	}
+
+	for i := 0; i < len(token); i++ {
+		if token[i] <= ' ' || token[i] > '~' {
+			return false
+		}
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		// Not a JWT, e.g. PKI token: expiry isn't checked locally.
+		return true
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(
+		strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false
+	}
+
+	var claims struct {
+		Exp *float64 `json:"exp"`
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return false
+	}
+
+	if claims.Exp != nil {
+		now := time.Now
+		if v.Now != nil {
+			now = v.Now
+		}
+		if now().Unix() >= int64(*claims.Exp) {
+			return false
+		}
+	}
+
+	return true
+}
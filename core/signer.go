@@ -175,10 +175,22 @@ func AuthHeaderValue(signature, accessKey string, signedHeaders []string) string
 type Signer struct {
 	Key    string
 	Secret string
+
+	// Mode selects where the signature is placed, header by default.
+	Mode SignMode
 }
 
-// SignRequest set Authorization header
+// SignRequest set Authorization header or signature query parameters
+// depending on the signer mode
 func (s *Signer) Sign(r *http.Request) error {
+	return s.SignWithMode(r, s.Mode)
+}
+
+// SignWithMode signs the request in the mode regardless of the signer mode.
+func (s *Signer) SignWithMode(r *http.Request, mode SignMode) error {
+	if mode == SignQuery {
+		return s.SignQuery(r)
+	}
 	var t time.Time
 	var err error
 	var dt string
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SignMode selects where request signature is placed.
+type SignMode int
+
+const (
+	// SignHeader places signature to Authorization header.
+	SignHeader SignMode = iota
+
+	// SignQuery places signature to query parameters. Only host header is
+	// signed, so the request survives proxies rewriting headers.
+	SignQuery
+)
+
+const (
+	QueryXAlgorithm     = "X-Sdk-Algorithm"
+	QueryXDate          = "X-Sdk-Date"
+	QueryXAccess        = "X-Sdk-Access"
+	QueryXSignedHeaders = "X-Sdk-SignedHeaders"
+	QueryXSignature     = "X-Sdk-Signature"
+)
+
+// ParseSignMode parses sign mode name: header or query.
+func ParseSignMode(s string) (SignMode, error) {
+	switch strings.ToLower(s) {
+	case "header":
+		return SignHeader, nil
+	case "query":
+		return SignQuery, nil
+	}
+	return SignHeader, fmt.Errorf("unknown sign mode %q", s)
+}
+
+func (m SignMode) String() string {
+	if m == SignQuery {
+		return "query"
+	}
+	return "header"
+}
+
+// SignQuery signs the request with signature query parameters. Signing
+// parameters except the signature itself are part of the canonical query
+// string.
+func (s *Signer) SignQuery(r *http.Request) error {
+	t := time.Now()
+	signedHeaders := []string{HeaderHost}
+
+	q := r.URL.Query()
+	q.Del(QueryXSignature)
+	q.Set(QueryXAlgorithm, Algorithm)
+	q.Set(QueryXDate, t.UTC().Format(BasicDateFormat))
+	q.Set(QueryXAccess, s.Key)
+	q.Set(QueryXSignedHeaders, strings.Join(signedHeaders, ";"))
+	r.URL.RawQuery = q.Encode()
+
+	canonicalRequest, err := CanonicalRequest(r, signedHeaders)
+	if err != nil {
+		return err
+	}
+	stringToSign, err := StringToSign(canonicalRequest, t)
+	if err != nil {
+		return err
+	}
+	signature, err := SignStringToSign(stringToSign, []byte(s.Secret))
+	if err != nil {
+		return err
+	}
+
+	// CanonicalRequest rewrites the query to the canonical form, so the
+	// signature is appended to exactly signed query.
+	r.URL.RawQuery += "&" + QueryXSignature + "=" + signature
+
+	return nil
+}
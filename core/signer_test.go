@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testKey    = "access-key"
+	testSecret = "secret-key"
+)
+
+func newSignedRequest(t *testing.T, mode SignMode) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(http.MethodGet,
+		"https://ces.example.com/V1.0/project/metrics?namespace=SYS.ECS"+
+			"&dim.0=instance_id,abc", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	s := &Signer{Key: testKey, Secret: testSecret, Mode: mode}
+
+	err = s.Sign(r)
+	if err != nil {
+		t.Fatalf("failed to sign request: %v", err)
+	}
+
+	return r
+}
+
+// signature recomputes the request signature as the gateway does.
+func signature(t *testing.T, r *http.Request, signedHeaders []string,
+	date, secret string) string {
+
+	t.Helper()
+
+	d, err := time.Parse(BasicDateFormat, date)
+	if err != nil {
+		t.Fatalf("failed to parse signature date %q: %v", date, err)
+	}
+
+	cr, err := CanonicalRequest(r, signedHeaders)
+	if err != nil {
+		t.Fatalf("failed to build canonical request: %v", err)
+	}
+
+	sts, err := StringToSign(cr, d)
+	if err != nil {
+		t.Fatalf("failed to build string to sign: %v", err)
+	}
+
+	sig, err := SignStringToSign(sts, []byte(secret))
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	return sig
+}
+
+var authHeaderRe = regexp.MustCompile(`^` + Algorithm +
+	` Access=([^,]+), SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+// checkHeaderSignature checks Authorization header signature of the request
+// and returns it.
+func checkHeaderSignature(t *testing.T, r *http.Request) string {
+	t.Helper()
+
+	m := authHeaderRe.FindStringSubmatch(r.Header.Get(HeaderAuthorization))
+	if m == nil {
+		t.Fatalf("malformed Authorization header %q",
+			r.Header.Get(HeaderAuthorization))
+	}
+	if m[1] != testKey {
+		t.Errorf("got access key %q, want %q", m[1], testKey)
+	}
+
+	want := signature(t, r.Clone(context.Background()),
+		strings.Split(m[2], ";"), r.Header.Get(HeaderXDate), testSecret)
+	if m[3] != want {
+		t.Errorf("got header signature %s, want %s", m[3], want)
+	}
+
+	return m[3]
+}
+
+// checkQuerySignature checks query parameters signature of the request and
+// returns it.
+func checkQuerySignature(t *testing.T, r *http.Request) string {
+	t.Helper()
+
+	q := r.URL.Query()
+
+	if got := q.Get(QueryXAlgorithm); got != Algorithm {
+		t.Errorf("got algorithm %q, want %q", got, Algorithm)
+	}
+	if got := q.Get(QueryXAccess); got != testKey {
+		t.Errorf("got access key %q, want %q", got, testKey)
+	}
+
+	sig := q.Get(QueryXSignature)
+
+	unsigned := r.Clone(context.Background())
+	q.Del(QueryXSignature)
+	unsigned.URL.RawQuery = q.Encode()
+
+	want := signature(t, unsigned,
+		strings.Split(q.Get(QueryXSignedHeaders), ";"), q.Get(QueryXDate),
+		testSecret)
+	if sig != want {
+		t.Errorf("got query signature %s, want %s", sig, want)
+	}
+
+	return sig
+}
+
+func TestSignHeader(t *testing.T) {
+	r := newSignedRequest(t, SignHeader)
+
+	checkHeaderSignature(t, r)
+
+	if q := r.URL.Query(); q.Get(QueryXSignature) != "" ||
+		q.Get(QueryXAccess) != "" {
+		t.Errorf("header signed request has signature query: %s",
+			r.URL.RawQuery)
+	}
+	if r.URL.Query().Get("namespace") != "SYS.ECS" {
+		t.Errorf("request query is lost: %s", r.URL.RawQuery)
+	}
+}
+
+func TestSignQuery(t *testing.T) {
+	r := newSignedRequest(t, SignQuery)
+
+	checkQuerySignature(t, r)
+
+	if h := r.Header.Get(HeaderAuthorization); h != "" {
+		t.Errorf("query signed request has Authorization header %q", h)
+	}
+	if r.URL.Query().Get("dim.0") != "instance_id,abc" {
+		t.Errorf("request query is lost: %s", r.URL.RawQuery)
+	}
+
+	// Host is signed, so the signature doesn't fit other host.
+	other := r.Clone(context.Background())
+	other.Host = "evil.example.com"
+	q := other.URL.Query()
+	sig := q.Get(QueryXSignature)
+	q.Del(QueryXSignature)
+	other.URL.RawQuery = q.Encode()
+
+	if signature(t, other, []string{HeaderHost}, q.Get(QueryXDate),
+		testSecret) == sig {
+		t.Errorf("signature doesn't depend on host")
+	}
+}
+
+func TestSignModesDistinct(t *testing.T) {
+	h := checkHeaderSignature(t, newSignedRequest(t, SignHeader))
+	q := checkQuerySignature(t, newSignedRequest(t, SignQuery))
+
+	if h == q {
+		t.Errorf("header and query signatures are equal: %s", h)
+	}
+}
+
+func TestSignWithModeOverridesSigner(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet,
+		"https://ces.example.com/V1.0/project/metrics", nil)
+
+	s := &Signer{Key: testKey, Secret: testSecret, Mode: SignHeader}
+
+	err := s.SignWithMode(r, SignQuery)
+	if err != nil {
+		t.Fatalf("failed to sign request: %v", err)
+	}
+
+	checkQuerySignature(t, r)
+}
+
+func TestParseSignMode(t *testing.T) {
+	for in, want := range map[string]SignMode{
+		"header": SignHeader,
+		"Query":  SignQuery,
+	} {
+		m, err := ParseSignMode(in)
+		if err != nil || m != want {
+			t.Errorf("ParseSignMode(%q) = %v, %v, want %v", in, m, err, want)
+		}
+	}
+
+	if _, err := ParseSignMode("body"); err == nil {
+		t.Errorf("ParseSignMode(body) got no error")
+	}
+}
+
+func TestCESClientQuerySignPaths(t *testing.T) {
+	signedInQuery := map[string]bool{}
+
+	ces := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			signedInQuery[r.URL.Path] = r.URL.Query().Get(QueryXSignature) !=
+				"" && r.Header.Get(HeaderAuthorization) == ""
+			w.Write([]byte(`{}`))
+		}))
+	defer ces.Close()
+
+	c := &CESClient{
+		URL:    ces.URL,
+		Signer: Signer{Key: testKey, Secret: testSecret},
+		Client: ces.Client(),
+		QuerySignPaths: []*regexp.Regexp{
+			regexp.MustCompile(`^(?:V1\.0/[^/]+/metric-data)$`)},
+	}
+
+	for _, p := range []string{"V1.0/project/metrics",
+		"/V1.0/project/metric-data"} {
+		res, err := c.Do(context.Background(), http.MethodGet, p, nil, nil,
+			nil)
+		if err != nil {
+			t.Fatalf("failed to do request: %v", err)
+		}
+		res.Body.Close()
+	}
+
+	if signedInQuery["/V1.0/project/metrics"] {
+		t.Errorf("default path is signed in query mode")
+	}
+	if !signedInQuery["/V1.0/project/metric-data"] {
+		t.Errorf("query sign path is signed in header mode")
+	}
+}
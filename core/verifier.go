@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	HeaderXAuthToken    = "X-Auth-Token"
+	HeaderXSubjectToken = "X-Subject-Token"
+)
+
+var (
+	ErrInvalidToken            = errors.New("invalid token")
+	ErrTokenServiceUnavailable = errors.New("token service unavailable")
+)
+
+// TokenVerifier verifies auth token and returns ID of the token owner.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (userID string, err error)
+}
+
+type tokenResp struct {
+	Token struct {
+		User struct {
+			ID string
+		}
+	}
+}
+
+// IAMVerifier verifies tokens using SberCloud IAM API.
+type IAMVerifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Verify checks token with IAM. Returns ErrInvalidToken if IAM rejects the
+// token and ErrTokenServiceUnavailable if IAM fails to check it. Request
+// cancelled by the caller results to ctx error.
+func (v *IAMVerifier) Verify(ctx context.Context, token string) (string, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		v.URL+"/auth/tokens", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	r.Header.Set(HeaderXAuthToken, token)
+	r.Header.Set(HeaderXSubjectToken, token)
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := v.Client.Do(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%w: %v", ErrTokenServiceUnavailable, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return "", fmt.Errorf("%w: IAM responded with status %d",
+			ErrTokenServiceUnavailable, res.StatusCode)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", ErrInvalidToken
+	}
+
+	var tr tokenResp
+
+	err = json.NewDecoder(res.Body).Decode(&tr)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal token check response: %w",
+			err)
+	}
+
+	return tr.Token.User.ID, nil
+}
+
+// FakeVerifier is a TokenVerifier for tests. It accepts tokens from Tokens
+// map and returns Err if it is set.
+type FakeVerifier struct {
+	Tokens map[string]string
+	Err    error
+}
+
+func (v *FakeVerifier) Verify(ctx context.Context, token string) (string, error) {
+	if v.Err != nil {
+		return "", v.Err
+	}
+	userID, ok := v.Tokens[token]
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	return userID, nil
+}
@@ -0,0 +1,119 @@
+// Package logger is a leveled logger on top of the standard log package.
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("LEVEL(%d)", l)
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses level name: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(s, "warning") {
+		return Warn, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+var (
+	std   = log.New(os.Stderr, "", log.LstdFlags)
+	level = int32(Info)
+)
+
+// SetOutput sets destination of logged messages, stderr by default.
+func SetOutput(w io.Writer) {
+	std.SetOutput(w)
+}
+
+// SetLevel sets minimal level of logged messages. It's safe to call
+// concurrently with logging.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// GetLevel returns current minimal level of logged messages.
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// Enabled reports whether messages of the level are logged.
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	std.Output(3, l.String()+" "+fmt.Sprintf(format, args...))
+}
+
+func Debugf(format string, args ...interface{}) {
+	logf(Debug, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	logf(Info, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	logf(Warn, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	logf(Error, format, args...)
+}
+
+// Fatalf logs message with error level regardless of current level and
+// exits.
+func Fatalf(format string, args ...interface{}) {
+	std.Output(2, Error.String()+" "+fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Sampler samples 1 of every N events. Zero N disables sampling.
+type Sampler struct {
+	n       uint64
+	counter uint64
+}
+
+func NewSampler(n int) *Sampler {
+	if n < 0 {
+		n = 0
+	}
+	return &Sampler{n: uint64(n)}
+}
+
+// Sample reports whether the current event is sampled.
+func (s *Sampler) Sample() bool {
+	if s.n == 0 {
+		return false
+	}
+	return atomic.AddUint64(&s.counter, 1)%s.n == 1%s.n
+}
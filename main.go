@@ -1,32 +1,57 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	_ "github.com/lib/pq"
 
+	"github.com/dimuls/sberhack-backend/api"
 	"github.com/dimuls/sberhack-backend/core"
-)
-
-const (
-	xAuthToken = "X-Auth-Token"
-	xSubjToken = "X-Subject-Token"
+	"github.com/dimuls/sberhack-backend/logger"
+	"github.com/dimuls/sberhack-backend/tracing"
 )
 
 var migrations = []string{
 	`create table if not exists dashboard (id bigserial primary key, user_id text, name text, graphs jsonb, unique(user_id, name))`,
+	`alter table dashboard add column if not exists updated_at timestamptz not null default now()`,
+	`alter table dashboard add column if not exists layout jsonb`,
+	`create table if not exists audit_log (id bigserial primary key, user_id text not null, action text not null, dashboard_id bigint not null, at timestamptz not null default now(), details jsonb)`,
+	`create index if not exists audit_log_dashboard_id_at_idx on audit_log (dashboard_id, at)`,
+	`create table if not exists dashboard_view (user_id text not null, dashboard_id bigint not null references dashboard (id) on delete cascade, viewed_at timestamptz not null default now(), primary key (user_id, dashboard_id))`,
+	`create index if not exists dashboard_view_user_id_viewed_at_idx on dashboard_view (user_id, viewed_at)`,
+	`create table if not exists dashboard_snapshot (id bigserial primary key, dashboard_id bigint not null references dashboard (id) on delete cascade, user_id text not null, graphs jsonb not null, data jsonb not null, created_at timestamptz not null default now())`,
+	`create index if not exists dashboard_snapshot_dashboard_id_created_at_idx on dashboard_snapshot (dashboard_id, created_at)`,
+	`alter table dashboard add column if not exists sort_order int not null default 0`,
+	`alter table dashboard add column if not exists is_favorite boolean not null default false`,
+	`create index if not exists dashboard_user_id_order_idx on dashboard (user_id, is_favorite desc, sort_order, name, id)`,
+	`create table if not exists idempotency_key (user_id text not null, key text not null, request_hash text not null, response jsonb, created_at timestamptz not null default now(), primary key (user_id, key))`,
+	`alter table dashboard add column if not exists description text`,
+	`alter table audit_log add column if not exists ip text`,
+	`create table if not exists dashboard_template (id int primary key, name text not null, graphs jsonb not null)`,
+	`insert into dashboard_template (id, name, graphs) values
+		(1, 'ECS overview', '[{"title": "CPU usage", "type": "line", "namespace": "SYS.ECS", "metric_name": "cpu_util"}, {"title": "Memory usage", "type": "line", "namespace": "SYS.ECS", "metric_name": "mem_util"}, {"title": "Disk read", "type": "area", "namespace": "SYS.ECS", "metric_name": "disk_read_bytes_rate"}, {"title": "Disk write", "type": "area", "namespace": "SYS.ECS", "metric_name": "disk_write_bytes_rate"}]'),
+		(2, 'Network traffic', '[{"title": "Inbound bandwidth", "type": "area", "namespace": "SYS.ECS", "metric_name": "network_incoming_bytes_rate_inband"}, {"title": "Outbound bandwidth", "type": "area", "namespace": "SYS.ECS", "metric_name": "network_outgoing_bytes_rate_inband"}]'),
+		(3, 'RDS PostgreSQL', '[{"title": "CPU usage", "type": "line", "namespace": "SYS.RDS", "metric_name": "rds001_cpu_util"}, {"title": "Memory usage", "type": "line", "namespace": "SYS.RDS", "metric_name": "rds002_mem_util"}, {"title": "Connections", "type": "bar", "namespace": "SYS.RDS", "metric_name": "rds042_database_connections"}]')
+		on conflict (id) do nothing`,
+	`update dashboard set graphs = '[]'::jsonb where graphs is null or graphs = 'null'::jsonb`,
+	`alter table dashboard alter column graphs set default '[]'::jsonb, alter column graphs set not null`,
+	`alter table dashboard add column if not exists copied_from bigint`,
 }
 
 func migrate(db *sql.DB) error {
@@ -39,284 +64,528 @@ func migrate(db *sql.DB) error {
 	return nil
 }
 
-const iamAPI = "https://iam.ru-moscow-1.hc.sbercloud.ru/v3"
-const cesAPI = "https://ces.ru-moscow-1.hc.sbercloud.ru/V1.0"
+// readyCheckInterval and readyCheckTimeout are an interval and a timeout of
+// startup IAM reachability checks.
+const (
+	readyCheckInterval = 2 * time.Second
+	readyCheckTimeout  = 5 * time.Second
+)
+
+// waitReady sets the server ready after IAM is reachable. IAM is checked
+// until it's reachable or ctx is done.
+func waitReady(ctx context.Context, s *api.Server) {
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+		err := s.CheckIAM(checkCtx)
+		cancel()
+		if err == nil {
+			s.SetReady(true)
+			logger.Infof("[startup] ready")
+			return
+		}
+
+		logger.Warnf("[startup] IAM check failed: %v", err)
 
-type TokenResp struct {
-	Token struct {
-		User struct {
-			ID string
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(readyCheckInterval):
 		}
 	}
 }
 
-type Dashboard struct {
-	ID     int             `db:"id" json:"id"`
-	Name   string          `db:"name" json:"name"`
-	Graphs json.RawMessage `db:"graphs" json:"graphs"`
+// Build info injected with -ldflags "-X main.commit=... -X main.buildTime=...".
+var (
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+const defaultIAMAPI = "https://iam.ru-moscow-1.hc.sbercloud.ru/v3"
+const defaultCESAPI = "https://ces.ru-moscow-1.hc.sbercloud.ru/V1.0"
+
+// defaultCESPathAllowlist allows metric read endpoints used by dashboards.
+const defaultCESPathAllowlist = `[\w-]+/metrics,[\w-]+/metric-data`
+
+const defaultRedactQueryKeys = "dim.0,dim.1,dim.2,dim.3,token,signature," +
+	"x-sdk-signature,x-auth-token"
+
+type config struct {
+	SignerKey       string
+	SignerSecret    string
+	PGURI           string
+	PGReplicaURI    string
+	IAMAPI          string
+	CESAPI          string
+	RedactQueryKeys []string
+	BodyLimit       int
+	MaxGraphsSize   int
+	MaxGraphs       int
+	CESProjectID    string
+	CatalogCacheTTL time.Duration
+	CursorSecret    string
+	AdminToken      string
+	DBTimeout       time.Duration
+	CESTimeout      time.Duration
+
+	BatchQueryRateLimit int
+	CESPathAllowlist    []string
+	CESLogSampling      int
+	CESBreakerFailures  int
+	CESBreakerCooldown  time.Duration
+	IdempotencyKeyTTL   time.Duration
+	PruneInterval       time.Duration
+	SnapshotRetention   time.Duration
+	InvalidTokenTTL     time.Duration
+	IAMMaxConcurrency   int
+	MaxEventSubscribers int
+	TrustedProxies      []string
+	AuditRetention      time.Duration
+	MaxTimeRange        time.Duration
+	AuthTokenHeader     string
+	CESSignMode         core.SignMode
+	CESQuerySignPaths   []string
+	CESSharedBodyLimit  int
+	IAMLocalPrecheck    bool
+	IPRateLimit         int
+	IPRateBurst         int
+	DefaultTemplateID   int
+	IAMAlertPercent     int
+
+	DashboardDataCacheTTL  time.Duration
+	DashboardDataCacheSize int
+
+	LogLevel logger.Level
+
+	Timeouts serverTimeouts
+
+	ShutdownDrainDelay time.Duration
+
+	ConfigFile string
+
+	CSRFEnabled     bool
+	MaintenanceMode bool
+
+	HTTPMaxIdleConnsPerHost int
+	HTTPIdleConnTimeout     time.Duration
+	HTTPTLSHandshakeTimeout time.Duration
 }
 
-type DashboardsRes struct {
-	Dashboards []Dashboard `json:"dashboard"`
+// serverTimeouts are HTTP server connection timeouts.
+type serverTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
 }
 
-type DashboardRes struct {
-	Dashboard Dashboard `json:"dashboard"`
+// validate checks timeouts are positive and the write timeout doesn't cut
+// responses before handlers time out themselves. Write timeout bounds the
+// whole response write, including streamed bodies, so it must exceed CES
+// timeout to not cut CES proxy streams. SSE streams outliving it are closed
+// and reconnected by clients.
+func (t serverTimeouts) validate(cesTimeout time.Duration) error {
+	if t.Read <= 0 || t.Write <= 0 || t.Idle <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+	if t.Write <= cesTimeout {
+		return fmt.Errorf("write timeout %s must be greater than"+
+			" CES timeout %s", t.Write, cesTimeout)
+	}
+	return nil
 }
 
-type AddDashboardsRes struct {
-	ID int `json:"id"`
+func getEnv(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
 }
 
-func main() {
+func getEnvList(key, def string) []string {
+	var l []string
+	for _, v := range strings.Split(getEnv(key, def), ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
 
-	s := core.Signer{
-		Key:    os.Getenv("SIGNER_KEY"),
-		Secret: os.Getenv("SIGNER_SECRET"),
+func getEnvURL(key, def string) string {
+	v := strings.TrimRight(getEnv(key, def), "/")
+	u, err := url.Parse(v)
+	if err != nil {
+		logger.Fatalf("failed to parse %s: %v", key, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		logger.Fatalf("failed to parse %s: absolute http(s) URL expected", key)
 	}
+	return v
+}
 
-	rawDB, err := sql.Open("postgres", os.Getenv("PG_URI"))
+func getEnvInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
 	if err != nil {
-		log.Fatal("failed to open db:", err)
+		logger.Fatalf("failed to parse %s: %v", key, err)
 	}
+	return i
+}
 
-	err = migrate(rawDB)
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
 	if err != nil {
-		log.Fatal("failed to migrate db:", err)
+		logger.Fatalf("failed to parse %s: %v", key, err)
 	}
+	return d
+}
 
-	db := goqu.New("postgres", rawDB)
+func getEnvBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logger.Fatalf("failed to parse %s: %v", key, err)
+	}
+	return b
+}
 
-	app := fiber.New(fiber.Config{
-		ReadTimeout: 10 * time.Second,
-	})
+func getEnvLogLevel(key string, def logger.Level) logger.Level {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	l, err := logger.ParseLevel(v)
+	if err != nil {
+		logger.Fatalf("failed to parse %s: %v", key, err)
+	}
+	return l
+}
 
-	app.Use(recover.New(), logger.New(logger.Config{
-		Next: func(c *fiber.Ctx) bool {
-			return string(c.Request().URI().Path()) == "/health-check"
-		},
-	}))
+func getEnvSignMode(key string, def core.SignMode) core.SignMode {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	m, err := core.ParseSignMode(v)
+	if err != nil {
+		logger.Fatalf("failed to parse %s: %v", key, err)
+	}
+	return m
+}
 
-	app.Get("/health-check", func(c *fiber.Ctx) error {
-		return c.SendStatus(http.StatusOK)
-	})
+func loadConfig() config {
+	return config{
+		SignerKey:       os.Getenv("SIGNER_KEY"),
+		SignerSecret:    os.Getenv("SIGNER_SECRET"),
+		PGURI:           os.Getenv("PG_URI"),
+		PGReplicaURI:    os.Getenv("PG_REPLICA_URI"),
+		IAMAPI:          getEnvURL("IAM_API_URL", defaultIAMAPI),
+		CESAPI:          getEnvURL("CES_API_URL", defaultCESAPI),
+		RedactQueryKeys: getEnvList("REDACT_QUERY_KEYS", defaultRedactQueryKeys),
+		BodyLimit:       getEnvInt("BODY_LIMIT", 1024*1024),
+		MaxGraphsSize:   getEnvInt("MAX_GRAPHS_SIZE", 256*1024),
+		MaxGraphs:       getEnvInt("MAX_GRAPHS_PER_DASHBOARD", 100),
+		CESProjectID:    os.Getenv("CES_PROJECT_ID"),
+		CatalogCacheTTL: getEnvDuration("CATALOG_CACHE_TTL", 5*time.Minute),
+		CursorSecret:    os.Getenv("CURSOR_SECRET"),
+		AdminToken:      os.Getenv("ADMIN_TOKEN"),
+		DBTimeout:       getEnvDuration("DB_TIMEOUT", 3*time.Second),
+		CESTimeout:      getEnvDuration("CES_TIMEOUT", 30*time.Second),
+
+		BatchQueryRateLimit: getEnvInt("BATCH_QUERY_RATE_LIMIT", 60),
+		CESLogSampling:      getEnvInt("CES_LOG_SAMPLING", 100),
+		CESBreakerFailures:  getEnvInt("CES_BREAKER_FAILURES", 5),
+		CESBreakerCooldown: getEnvDuration("CES_BREAKER_COOLDOWN",
+			30*time.Second),
+		IdempotencyKeyTTL: getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		PruneInterval:     getEnvDuration("PRUNE_INTERVAL", time.Hour),
+		SnapshotRetention: getEnvDuration("SNAPSHOT_RETENTION",
+			90*24*time.Hour),
+		CESPathAllowlist: getEnvList("CES_PATH_ALLOWLIST",
+			defaultCESPathAllowlist),
+		InvalidTokenTTL:   getEnvDuration("INVALID_TOKEN_TTL", 10*time.Second),
+		IAMMaxConcurrency: getEnvInt("IAM_MAX_CONCURRENCY", 16),
+
+		MaxEventSubscribers: getEnvInt("MAX_EVENT_SUBSCRIBERS", 10),
+		TrustedProxies:      getEnvList("TRUSTED_PROXIES", ""),
+		AuditRetention: time.Duration(getEnvInt("AUDIT_RETENTION_DAYS",
+			365)) * 24 * time.Hour,
+		MaxTimeRange:    getEnvDuration("MAX_TIME_RANGE", 90*24*time.Hour),
+		AuthTokenHeader: getEnv("AUTH_TOKEN_HEADER", core.HeaderXAuthToken),
+		CESSignMode:     getEnvSignMode("CES_SIGN_MODE", core.SignHeader),
+
+		CESQuerySignPaths: getEnvList("CES_QUERY_SIGN_PATHS", ""),
+		CESSharedBodyLimit: getEnvInt("CES_SHARED_BODY_LIMIT",
+			1024*1024),
+
+		IAMLocalPrecheck: getEnvBool("IAM_LOCAL_PRECHECK", false),
+		IPRateLimit:      getEnvInt("IP_RATE_LIMIT", 50),
+		IPRateBurst:      getEnvInt("IP_RATE_BURST", 100),
+
+		DefaultTemplateID: getEnvInt("DEFAULT_TEMPLATE_ID", 1),
+		IAMAlertPercent:   getEnvInt("IAM_UNAVAILABLE_ALERT_PERCENT", 50),
+
+		DashboardDataCacheTTL: getEnvDuration("DASHBOARD_DATA_CACHE_TTL",
+			time.Minute),
+		DashboardDataCacheSize: getEnvInt("DASHBOARD_DATA_CACHE_SIZE", 1000),
+
+		LogLevel: getEnvLogLevel("LOG_LEVEL", logger.Info),
+
+		Timeouts: serverTimeouts{
+			Read:  getEnvDuration("READ_TIMEOUT", 10*time.Second),
+			Write: getEnvDuration("WRITE_TIMEOUT", 5*time.Minute),
+			Idle:  getEnvDuration("IDLE_TIMEOUT", 2*time.Minute),
+		},
 
-	r := app.Group("/", func(c *fiber.Ctx) error {
+		ShutdownDrainDelay: getEnvDuration("SHUTDOWN_DRAIN_DELAY",
+			5*time.Second),
 
-		token := string(c.Request().Header.Peek(xAuthToken))
+		ConfigFile: os.Getenv("CONFIG_FILE"),
 
-		if token == "" {
-			return c.Status(http.StatusForbidden).SendString("token is absent")
-		}
+		CSRFEnabled:     getEnvBool("CSRF_ENABLED", false),
+		MaintenanceMode: getEnvBool("MAINTENANCE_MODE", false),
 
-		url := iamAPI + "/auth/tokens"
+		HTTPMaxIdleConnsPerHost: getEnvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 32),
+		HTTPIdleConnTimeout: getEnvDuration("HTTP_IDLE_CONN_TIMEOUT",
+			90*time.Second),
+		HTTPTLSHandshakeTimeout: getEnvDuration("HTTP_TLS_HANDSHAKE_TIMEOUT",
+			10*time.Second),
+	}
+}
 
-		r, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).SendString(
-				"failed to create http request request: " + err.Error())
-		}
+// newHTTPClient creates http client for all outbound requests to SberCloud.
+func newHTTPClient(cfg config) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = cfg.HTTPMaxIdleConnsPerHost
+	t.IdleConnTimeout = cfg.HTTPIdleConnTimeout
+	t.TLSHandshakeTimeout = cfg.HTTPTLSHandshakeTimeout
+	return &http.Client{Transport: t}
+}
 
-		r.Header.Set(xAuthToken, token)
-		r.Header.Set(xSubjToken, token)
-		r.Header.Set("Content-Type", "application/json")
+func main() {
 
-		res, err := http.DefaultClient.Do(r)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).SendString(
-				"failed to do http request: " + err.Error())
-		}
+	startTime := time.Now()
 
-		defer res.Body.Close()
+	cfg := loadConfig()
 
-		if res.StatusCode >= 500 {
-			return c.Status(http.StatusInternalServerError).
-				SendString("unable to check token")
-		}
+	logger.SetLevel(cfg.LogLevel)
 
-		if res.StatusCode != http.StatusOK {
-			return c.Status(http.StatusUnauthorized).
-				SendString("invalid token")
-		}
+	err := cfg.Timeouts.validate(cfg.CESTimeout)
+	if err != nil {
+		logger.Fatalf("invalid server timeouts: %v", err)
+	}
 
-		var tokenRes TokenResp
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		logger.Fatalf("failed to init tracing: %v", err)
+	}
 
-		err = json.NewDecoder(res.Body).Decode(&tokenRes)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to unmarshal token check response")
-		}
+	logger.Infof("[startup] connecting to db")
 
-		c.Locals("userID", tokenRes.Token.User.ID)
+	rawDB, err := sql.Open("postgres", cfg.PGURI)
+	if err != nil {
+		logger.Fatalf("failed to open db: %v", err)
+	}
 
-		return c.Next()
-	})
+	err = rawDB.Ping()
+	if err != nil {
+		logger.Fatalf("failed to ping db: %v", err)
+	}
 
-	r.Get("/ces/*", func(c *fiber.Ctx) error {
+	logger.Infof("[startup] migrating db")
 
-		url := cesAPI
+	err = migrate(rawDB)
+	if err != nil {
+		logger.Fatalf("failed to migrate db: %v", err)
+	}
 
-		path := c.Params("*")
-		if path != "" {
-			url += "/" + path
-		}
+	logger.Infof("[startup] db migrated")
 
-		query := string(c.Request().URI().QueryString())
-		if query != "" {
-			url += "?" + query
-		}
+	db := goqu.New("postgres", tracing.DB{DB: rawDB})
 
-		log.Printf("[ces request] url=%s\n", url)
+	var replica *goqu.Database
 
-		r, err := http.NewRequest(http.MethodGet, url, nil)
+	if cfg.PGReplicaURI != "" {
+		rawReplica, err := sql.Open("postgres", cfg.PGReplicaURI)
 		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to create http request: " + err.Error())
+			logger.Fatalf("failed to open replica db: %v", err)
 		}
 
-		r.Header.Add("x-stage", "RELEASE")
-		s.Sign(r)
-
-		res, err := http.DefaultClient.Do(r)
+		err = rawReplica.Ping()
 		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to do http request: " + err.Error())
+			logger.Fatalf("failed to ping replica db: %v", err)
 		}
 
-		defer res.Body.Close()
-
-		return c.Status(res.StatusCode).SendStream(res.Body)
-	})
+		replica = goqu.New("postgres", tracing.DB{DB: rawReplica})
+	}
 
-	r.Get("/dashboards", func(c *fiber.Ctx) error {
-		userID, ok := c.Locals("userID").(string)
-		if !ok {
-			return c.Status(http.StatusInternalServerError).
-				SendString("expected local userID string")
-		}
+	client := newHTTPClient(cfg)
 
-		var ds []Dashboard
+	var verifier core.TokenVerifier = core.NewGuardedVerifier(
+		&core.IAMVerifier{
+			URL:    cfg.IAMAPI,
+			Client: client,
+		}, cfg.InvalidTokenTTL, cfg.IAMMaxConcurrency)
 
-		db.Select("id", "name", "graphs").From("dashboard").
-			Where(goqu.Ex{"user_id": userID}).Executor().ScanStructs(&ds)
+	// Local precheck only rejects tokens, accepted ones still go to IAM.
+	if cfg.IAMLocalPrecheck {
+		verifier = &core.PrecheckVerifier{Verifier: verifier}
+	}
 
-		return c.JSON(DashboardsRes{Dashboards: ds})
+	s, err := api.NewServer(db, replica, core.Signer{
+		Key:    cfg.SignerKey,
+		Secret: cfg.SignerSecret,
+		Mode:   cfg.CESSignMode,
+	}, verifier, client, api.Config{
+		IAMAPI:          cfg.IAMAPI,
+		CESAPI:          cfg.CESAPI,
+		CESProjectID:    cfg.CESProjectID,
+		CatalogCacheTTL: cfg.CatalogCacheTTL,
+		RedactQueryKeys: cfg.RedactQueryKeys,
+		MaxGraphsSize:   cfg.MaxGraphsSize,
+		MaxGraphs:       cfg.MaxGraphs,
+		CursorSecret:    cfg.CursorSecret,
+		AdminToken:      cfg.AdminToken,
+		Commit:          commit,
+		BuildTime:       buildTime,
+		StartTime:       startTime,
+		DBTimeout:       cfg.DBTimeout,
+		CESTimeout:      cfg.CESTimeout,
+
+		BatchQueryRateLimit: cfg.BatchQueryRateLimit,
+		CESPathAllowlist:    cfg.CESPathAllowlist,
+		CESLogSampling:      cfg.CESLogSampling,
+		CESBreakerFailures:  cfg.CESBreakerFailures,
+		CESBreakerCooldown:  cfg.CESBreakerCooldown,
+		IdempotencyKeyTTL:   cfg.IdempotencyKeyTTL,
+		PruneInterval:       cfg.PruneInterval,
+		SnapshotRetention:   cfg.SnapshotRetention,
+		MaxEventSubscribers: cfg.MaxEventSubscribers,
+		AuditRetention:      cfg.AuditRetention,
+		MaxTimeRange:        cfg.MaxTimeRange,
+		AuthTokenHeader:     cfg.AuthTokenHeader,
+		CSRFEnabled:         cfg.CSRFEnabled,
+		MaintenanceMode:     cfg.MaintenanceMode,
+		IPRateLimit:         cfg.IPRateLimit,
+		IPRateBurst:         cfg.IPRateBurst,
+		DefaultTemplateID:   cfg.DefaultTemplateID,
+		IAMAlertPercent:     cfg.IAMAlertPercent,
+		CESQuerySignPaths:   cfg.CESQuerySignPaths,
+		CESSharedBodyLimit:  cfg.CESSharedBodyLimit,
+
+		DashboardDataCacheTTL:  cfg.DashboardDataCacheTTL,
+		DashboardDataCacheSize: cfg.DashboardDataCacheSize,
 	})
+	if err != nil {
+		logger.Fatalf("failed to create server: %v", err)
+	}
 
-	r.Get("/dashboards/:id", func(c *fiber.Ctx) error {
-		userID, ok := c.Locals("userID").(string)
-		if !ok {
-			return c.Status(http.StatusInternalServerError).
-				SendString("expected local userID string")
-		}
-
-		dashboardID, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return c.Status(http.StatusBadRequest).
-				SendString("failed to parse dashboard ID")
-		}
-
-		var d Dashboard
-
-		found, err := db.Select("id", "user_id", "name", "graphs").
-			From("dashboard").
-			Where(goqu.Ex{"id": dashboardID, "user_id": userID}).
-			Executor().ScanStruct(&d)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to get dashboard from DB: " + err.Error())
-		}
-		if !found {
-			return c.SendStatus(http.StatusNotFound)
-		}
+	fiberConfig := fiber.Config{
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+		BodyLimit:    cfg.BodyLimit,
+		ErrorHandler: api.ErrorHandler,
+		JSONEncoder:  api.JSONEncoder,
+		JSONDecoder:  api.JSONDecoder,
+	}
 
-		return c.JSON(DashboardRes{Dashboard: d})
-	})
+	// X-Forwarded-For is trusted only from the configured proxies, so
+	// clients can't spoof their IP with it.
+	if len(cfg.TrustedProxies) > 0 {
+		fiberConfig.EnableTrustedProxyCheck = true
+		fiberConfig.TrustedProxies = cfg.TrustedProxies
+		fiberConfig.ProxyHeader = fiber.HeaderXForwardedFor
+		fiberConfig.EnableIPValidation = true
+	}
 
-	r.Delete("/dashboards/:id", func(c *fiber.Ctx) error {
-		userID, ok := c.Locals("userID").(string)
-		if !ok {
-			return c.Status(http.StatusInternalServerError).
-				SendString("expected local userID string")
-		}
+	app := fiber.New(fiberConfig)
 
-		dashboardID, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return c.Status(http.StatusBadRequest).
-				SendString("failed to parse dashboard ID")
-		}
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace:  true,
+		StackTraceHandler: api.StackTraceHandler,
+	}), fiberlogger.New(fiberlogger.Config{
+		Next: func(c *fiber.Ctx) bool {
+			switch string(c.Request().URI().Path()) {
+			case "/health-check", "/ready":
+				return true
+			}
+			return false
+		},
+	}))
 
-		_, err = db.From("dashboard").Delete().Where(
-			goqu.Ex{"id": dashboardID, "user_id": userID}).Executor().Exec()
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to delete dashboard from db: " + err.Error())
-		}
+	s.RegisterRoutes(app)
 
-		return c.SendStatus(http.StatusOK)
-	})
+	// Hot-reloadable settings of the config file override the environment
+	// from the start, not only after the first SIGHUP.
+	if cfg.ConfigFile != "" {
+		reloadConfig(s, cfg.ConfigFile)
+	}
 
-	r.Post("/dashboards", func(c *fiber.Ctx) error {
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
 
-		userID, ok := c.Locals("userID").(string)
-		if !ok {
-			return c.Status(http.StatusInternalServerError).
-				SendString("expected local userID string")
+	go func() {
+		for range reloads {
+			logger.Infof("[reload] reloading config")
+			reloadConfig(s, cfg.ConfigFile)
 		}
+	}()
 
-		var d Dashboard
+	ctx, cancel := context.WithCancel(context.Background())
 
-		err := json.Unmarshal(c.Body(), &d)
-		if err != nil {
-			return c.Status(http.StatusBadRequest).SendString(
-				"failed to JSON unmarshal dashboard: " + err.Error())
-		}
+	var wg sync.WaitGroup
 
-		var id int
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.RunPruner(ctx)
+	}()
 
-		_, err = db.Insert("dashboard").Cols("user_id", "name", "graphs").
-			Vals(goqu.Vals{userID, d.Name, goqu.L("?::jsonb", string(d.Graphs))}).
-			Returning("id").Executor().ScanVal(&id)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to insert dashboard to db:" + err.Error())
-		}
+	// Server is ready after migrations and IAM check, until then /ready
+	// responds with 503.
+	checked := make(chan struct{})
+	go func() {
+		defer close(checked)
+		waitReady(ctx, s)
+	}()
 
-		return c.JSON(AddDashboardsRes{ID: id})
-	})
+	go app.Listen("0.0.0.0:80")
 
-	r.Put("/dashboards", func(c *fiber.Ctx) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-		userID, ok := c.Locals("userID").(string)
-		if !ok {
-			return c.Status(http.StatusInternalServerError).
-				SendString("expected local userID string")
-		}
+	<-signals
 
-		var d Dashboard
+	cancel()
+	<-checked
 
-		err := json.Unmarshal(c.Body(), &d)
-		if err != nil {
-			return c.Status(http.StatusBadRequest).SendString(
-				"failed to JSON unmarshal dashboard: " + err.Error())
-		}
+	s.SetReady(false)
 
-		_, err = db.Update("dashboard").Set(goqu.Record{
-			"name":   d.Name,
-			"graphs": goqu.L("?::jsonb", string(d.Graphs)),
-		}).Where(goqu.Ex{"id": d.ID, "user_id": userID}).Executor().Exec()
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).
-				SendString("failed to update dashboard in db:" + err.Error())
-		}
+	logger.Infof("[shutdown] not ready, draining for %s",
+		cfg.ShutdownDrainDelay)
 
-		return c.SendStatus(http.StatusOK)
-	})
+	time.Sleep(cfg.ShutdownDrainDelay)
 
-	go app.Listen("0.0.0.0:80")
+	err = app.Shutdown()
+	if err != nil {
+		logger.Errorf("failed to shutdown server: %v", err)
+	}
 
-	signals := make(chan os.Signal)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	wg.Wait()
 
-	<-signals
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		logger.Errorf("failed to shutdown tracing: %v", err)
+	}
 }
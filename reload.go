@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimuls/sberhack-backend/api"
+	"github.com/dimuls/sberhack-backend/logger"
+)
+
+// reloadableKeys are settings applied on SIGHUP without restart.
+var reloadableKeys = map[string]bool{
+	"LOG_LEVEL":         true,
+	"MAINTENANCE_MODE":  true,
+	"CATALOG_CACHE_TTL": true,
+}
+
+// readConfigFile reads KEY=VALUE lines of the config file. Empty lines and
+// lines starting with # are skipped.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kvs := map[string]string{}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		kvs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return kvs, s.Err()
+}
+
+// reloadConfig applies hot-reloadable settings from the environment and the
+// config file overriding it if path isn't empty. Unlike startup, invalid
+// values are logged and skipped, so a bad reload doesn't stop the service.
+// Maintenance mode switched with admin API is overwritten by reload.
+func reloadConfig(s *api.Server, path string) {
+	file := map[string]string{}
+
+	if path != "" {
+		var err error
+		file, err = readConfigFile(path)
+		if err != nil {
+			logger.Errorf("[reload] failed to read config file: %v", err)
+			return
+		}
+	}
+
+	lookup := func(key string) (string, bool) {
+		if v, ok := file[key]; ok {
+			return v, true
+		}
+		return os.LookupEnv(key)
+	}
+
+	if v, ok := lookup("LOG_LEVEL"); ok {
+		l, err := logger.ParseLevel(v)
+		if err != nil {
+			logger.Errorf("[reload] failed to parse LOG_LEVEL: %v", err)
+		} else {
+			logger.SetLevel(l)
+			logger.Infof("[reload] log level set to %s", l)
+		}
+	}
+
+	if v, ok := lookup("MAINTENANCE_MODE"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.Errorf("[reload] failed to parse MAINTENANCE_MODE: %v", err)
+		} else {
+			s.SetMaintenance(enabled)
+			logger.Infof("[reload] maintenance mode enabled=%t", enabled)
+		}
+	}
+
+	if v, ok := lookup("CATALOG_CACHE_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Errorf("[reload] failed to parse CATALOG_CACHE_TTL: %v",
+				err)
+		} else {
+			s.SetCatalogCacheTTL(ttl)
+			logger.Infof("[reload] catalog cache TTL set to %s", ttl)
+		}
+	}
+
+	var ignored []string
+	for k := range file {
+		if !reloadableKeys[k] {
+			ignored = append(ignored, k)
+		}
+	}
+
+	sort.Strings(ignored)
+
+	for _, k := range ignored {
+		logger.Warnf("[reload] %s ignored on reload: restart required", k)
+	}
+}
@@ -0,0 +1,116 @@
+// Package tracing sets up OpenTelemetry tracing of the service.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/dimuls/sberhack-backend"
+	defaultServiceName  = "sberhack-backend"
+)
+
+// Init sets up global tracer provider exporting spans via OTLP configured
+// with standard OTEL_EXPORTER_OTLP_* env vars. Tracing stays no-op if no
+// OTLP endpoint is configured. Returned shutdown flushes pending spans.
+func Init(ctx context.Context) (shutdown func(context.Context) error,
+	err error) {
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		os.Setenv("OTEL_SERVICE_NAME", defaultServiceName)
+	}
+
+	res, err := resource.New(ctx, resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res))
+
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Start starts span of the global tracer.
+func Start(ctx context.Context, name string,
+	attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithAttributes(attrs...))
+}
+
+// End records err in the span if it isn't nil and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// DB wraps sql.DB to trace queries. Queries of transactions are traced as
+// the transaction begin only.
+type DB struct {
+	*sql.DB
+}
+
+func (db DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx,
+	error) {
+	ctx, span := Start(ctx, "db.begin")
+	tx, err := db.DB.BeginTx(ctx, opts)
+	End(span, err)
+	return tx, err
+}
+
+func (db DB) ExecContext(ctx context.Context, query string,
+	args ...interface{}) (sql.Result, error) {
+	ctx, span := Start(ctx, "db.exec",
+		attribute.String("db.statement", query))
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	End(span, err)
+	return res, err
+}
+
+func (db DB) QueryContext(ctx context.Context, query string,
+	args ...interface{}) (*sql.Rows, error) {
+	ctx, span := Start(ctx, "db.query",
+		attribute.String("db.statement", query))
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	End(span, err)
+	return rows, err
+}
+
+func (db DB) QueryRowContext(ctx context.Context, query string,
+	args ...interface{}) *sql.Row {
+	ctx, span := Start(ctx, "db.query",
+		attribute.String("db.statement", query))
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	End(span, row.Err())
+	return row
+}